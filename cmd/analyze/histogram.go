@@ -0,0 +1,154 @@
+package main
+
+import "math"
+
+// histogramSubBuckets is the number of equal-width linear slots each
+// power-of-two bucket is divided into, trading a bit of quantile accuracy
+// for a histogram whose memory footprint never grows with the sample count.
+const histogramSubBuckets = 64
+
+// histogramBuckets covers every power of two representable by a
+// nanosecond-valued time.Duration.
+const histogramBuckets = 64 * histogramSubBuckets
+
+// histogram accumulates nanosecond-valued samples into a fixed number of
+// log2-sized buckets (HDR-style), so a process() pass over a huge samples
+// file can estimate percentiles in O(1) memory instead of holding every
+// sample in a slice. Mean and standard deviation are tracked exactly via
+// running sums; only the quantiles are approximate, interpolated from the
+// bucket a given rank falls into.
+type histogram struct {
+	counts [histogramBuckets]uint64
+	count  uint64
+	sum    float64
+	sumSq  float64
+}
+
+func (h *histogram) Add(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	h.counts[bucketIndex(v)]++
+	h.count++
+	f := float64(v)
+	h.sum += f
+	h.sumSq += f * f
+}
+
+// bucketIndex maps v to one of histogramSubBuckets linear slots within the
+// power-of-two range [2^k, 2^(k+1)) that v falls into.
+func bucketIndex(v int64) int {
+	if v < 1 {
+		return 0
+	}
+	k := 63
+	for b := 0; b < 63; b++ {
+		if int64(1)<<(b+1) > v {
+			k = b
+			break
+		}
+	}
+	lo := int64(1) << k
+	hi := lo * 2
+	sub := int((v - lo) * histogramSubBuckets / (hi - lo))
+	if sub >= histogramSubBuckets {
+		sub = histogramSubBuckets - 1
+	}
+	return k*histogramSubBuckets + sub
+}
+
+// bucketBounds returns the [lo, hi) nanosecond range represented by
+// counts index i.
+func bucketBounds(i int) (int64, int64) {
+	k := i / histogramSubBuckets
+	sub := i % histogramSubBuckets
+	lo := int64(1) << k
+	hi := lo * 2
+	width := hi - lo
+	blo := lo + int64(sub)*width/histogramSubBuckets
+	bhi := lo + int64(sub+1)*width/histogramSubBuckets
+	return blo, bhi
+}
+
+// Quantile returns an estimate of the p-th quantile (0 <= p <= 1) by
+// walking buckets in ascending order and linearly interpolating within the
+// bucket that contains the target rank, assuming samples are spread evenly
+// across that bucket's range.
+func (h *histogram) Quantile(p float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+	target := uint64(p * float64(h.count))
+	var seen uint64
+	for i, c := range h.counts {
+		if seen+c > target {
+			lo, hi := bucketBounds(i)
+			if c == 0 {
+				return float64(lo)
+			}
+			frac := float64(target-seen) / float64(c)
+			return float64(lo) + frac*float64(hi-lo)
+		}
+		seen += c
+	}
+	for i := len(h.counts) - 1; i >= 0; i-- {
+		if h.counts[i] > 0 {
+			_, hi := bucketBounds(i)
+			return float64(hi)
+		}
+	}
+	return 0
+}
+
+// histogramAccumulator is the O(1)-memory latencyAccumulator used when
+// process runs with --histogram: each metric gets its own fixed-size
+// histogram instead of an ever-growing slice.
+type histogramAccumulator struct {
+	slowRt, slowWt, slowRtt histogram
+	fastRt, fastWt, fastRtt histogram
+}
+
+func (a *histogramAccumulator) addSlow(rt, wt, rtt int64) {
+	a.slowRt.Add(rt)
+	a.slowWt.Add(wt)
+	a.slowRtt.Add(rtt)
+}
+
+func (a *histogramAccumulator) addFast(rt, wt, rtt int64) {
+	a.fastRt.Add(rt)
+	a.fastWt.Add(wt)
+	a.fastRtt.Add(rtt)
+}
+
+func (a *histogramAccumulator) stats() (slowRt, slowWt, slowRtt, fastRt, fastWt, fastRtt stats) {
+	return a.slowRt.stats(), a.slowWt.stats(), a.slowRtt.stats(),
+		a.fastRt.stats(), a.fastWt.stats(), a.fastRtt.stats()
+}
+
+// stats summarizes a histogram the same way newStats summarizes a slice of
+// exact samples, so both code paths feed the same Record/CSV columns.
+func (h *histogram) stats() stats {
+	if h.count == 0 {
+		return stats{}
+	}
+	mean := h.sum / float64(h.count)
+	variance := h.sumSq/float64(h.count) - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+	stdDev := math.Sqrt(variance)
+	var cv float64
+	if mean != 0 {
+		cv = stdDev / mean
+	}
+	return stats{
+		Average: mean,
+		StdDev:  stdDev,
+		CV:      cv,
+		P50:     h.Quantile(0.50),
+		P90:     h.Quantile(0.90),
+		P95:     h.Quantile(0.95),
+		P99:     h.Quantile(0.99),
+		P999:    h.Quantile(0.999),
+	}
+}
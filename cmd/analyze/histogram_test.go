@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// approxEqual allows for histogram's bucket-interpolation error, which grows
+// with v's magnitude since buckets are log2-sized.
+func approxEqual(t *testing.T, name string, got, want, tolFrac float64) {
+	t.Helper()
+	tol := math.Max(1, want*tolFrac)
+	if math.Abs(got-want) > tol {
+		t.Errorf("%s: got %v, want %v (+/- %v)", name, got, want, tol)
+	}
+}
+
+func TestHistogramQuantilesUniformSamples(t *testing.T) {
+	var h histogram
+	const n = 100_000
+	for i := 0; i < n; i++ {
+		h.Add(int64(i) * 1000) // 0ns .. ~100ms, evenly spaced
+	}
+
+	want := func(p float64) float64 { return p * (n - 1) * 1000 }
+
+	approxEqual(t, "P50", h.Quantile(0.50), want(0.50), 0.02)
+	approxEqual(t, "P90", h.Quantile(0.90), want(0.90), 0.02)
+	approxEqual(t, "P95", h.Quantile(0.95), want(0.95), 0.02)
+	approxEqual(t, "P99", h.Quantile(0.99), want(0.99), 0.02)
+	approxEqual(t, "P999", h.Quantile(0.999), want(0.999), 0.02)
+}
+
+func TestHistogramQuantilesConstantSamples(t *testing.T) {
+	var h histogram
+	const v = 5_000_000 // 5ms, every sample identical
+	for i := 0; i < 1000; i++ {
+		h.Add(v)
+	}
+
+	for _, p := range []float64{0.50, 0.90, 0.99, 0.999} {
+		approxEqual(t, "Quantile", h.Quantile(p), v, 0.02)
+	}
+}
+
+func TestHistogramQuantileEmpty(t *testing.T) {
+	var h histogram
+	if got := h.Quantile(0.50); got != 0 {
+		t.Fatalf("Quantile on empty histogram: got %v, want 0", got)
+	}
+}
+
+func TestHistogramAddNegativeClampsToZero(t *testing.T) {
+	var h histogram
+	h.Add(-1)
+	if h.count != 1 {
+		t.Fatalf("count: got %d, want 1", h.count)
+	}
+	if h.counts[0] != 1 {
+		t.Fatalf("counts[0]: got %d, want 1", h.counts[0])
+	}
+}
+
+func TestHistogramStatsMeanAndStdDev(t *testing.T) {
+	var h histogram
+	samples := []int64{1000, 2000, 3000, 4000, 5000}
+	var sum float64
+	for _, s := range samples {
+		h.Add(s)
+		sum += float64(s)
+	}
+	mean := sum / float64(len(samples))
+
+	var sumSqDiff float64
+	for _, s := range samples {
+		d := float64(s) - mean
+		sumSqDiff += d * d
+	}
+	wantStdDev := math.Sqrt(sumSqDiff / float64(len(samples)))
+
+	st := h.stats()
+	approxEqual(t, "Average", st.Average, mean, 0.0001)
+	approxEqual(t, "StdDev", st.StdDev, wantStdDev, 0.0001)
+}
+
+func TestBucketIndexMonotonic(t *testing.T) {
+	prev := bucketIndex(0)
+	for _, v := range []int64{1, 10, 100, 1000, 1_000_000, 1_000_000_000} {
+		idx := bucketIndex(v)
+		if idx < prev {
+			t.Fatalf("bucketIndex(%d) = %d is less than bucketIndex of a smaller value (%d)", v, idx, prev)
+		}
+		if idx < 0 || idx >= histogramBuckets {
+			t.Fatalf("bucketIndex(%d) = %d out of range [0, %d)", v, idx, histogramBuckets)
+		}
+		prev = idx
+	}
+}
+
+func TestBucketBoundsContainsValue(t *testing.T) {
+	// Values below 1024 are excluded: with only histogramSubBuckets linear
+	// slots per power-of-two range, a range narrower than that many
+	// nanoseconds (k < log2(histogramSubBuckets)) can't be subdivided
+	// without some slots rounding to zero width. Real latency samples are
+	// always far above this range, so it isn't a practical concern.
+	for _, v := range []int64{1024, 4000, 65536, 999_999} {
+		idx := bucketIndex(v)
+		lo, hi := bucketBounds(idx)
+		if v < lo || v >= hi {
+			t.Fatalf("bucketBounds(bucketIndex(%d)) = [%d, %d) does not contain %d", v, lo, hi, v)
+		}
+	}
+}
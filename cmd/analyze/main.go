@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -12,62 +13,358 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"golang.org/x/sync/errgroup"
+	"gonum.org/v1/gonum/stat"
 )
 
 var (
 	inputDirectory = flag.String("input-directory", "", "Directory of input files")
 	outputFile     = flag.String("output-file", "", "Output file")
 	concurrency    = flag.Uint("concurrency", 1, "Number of files to analyze concurrently")
+	useHistogram   = flag.Bool("histogram", false, "Stream samples into a fixed-size log-bucketed histogram instead of holding every sample in memory (approximate percentiles, O(1) memory)")
+	format         = flag.String("format", "csv", "Output format: csv, json, jsonl or influx")
+	decimal        = flag.String("decimal", ".", "Decimal separator for csv output: . or ,")
 )
 
 var (
-	header = []string{
+	latencyHeaders = []string{
+		"average",
+		"stddev",
+		"cv",
+		"p50",
+		"p90",
+		"p95",
+		"p99",
+		"p999",
+	}
+	latencyMetrics = []string{
+		"slow_rt",
+		"slow_wt",
+		"slow_rtt",
+		"fast_rt",
+		"fast_wt",
+		"fast_rtt",
+	}
+	header = buildHeader()
+)
+
+// buildHeader appends an average/stddev/cv/percentile column for each
+// metric in latencyMetrics, in latencyHeaders order, after the fixed
+// block-identifying columns.
+func buildHeader() []string {
+	h := []string{
 		"alg",
 		"fast_int",
 		"slow_int",
 		"tot_requests",
 		"slow_percent",
-		"average_slow_rt",
-		"average_slow_wt",
-		"average_slow_rtt",
-		"average_fast_rt",
-		"average_fast_wt",
-		"average_fast_rtt",
 	}
-)
+	for _, metric := range latencyMetrics {
+		for _, lh := range latencyHeaders {
+			h = append(h, fmt.Sprintf("%s_%s", lh, metric))
+		}
+	}
+	return h
+}
 
 type Config struct {
 	inputDirectory string
 	outputFile     string
 	concurrency    uint
+	useHistogram   bool
+	format         string
+	decimal        string
+}
+
+// stats holds the average/dispersion/percentile summary of one latency
+// metric for a single file, whether it was computed from exact samples or
+// estimated from a histogram.
+type stats struct {
+	Average float64
+	StdDev  float64
+	CV      float64
+	P50     float64
+	P90     float64
+	P95     float64
+	P99     float64
+	P999    float64
+}
+
+// newStats summarizes samples, which need not be sorted. It mutates
+// samples in place (sorting it) since every caller is done with its slice
+// right after.
+func newStats(samples []float64) stats {
+	if len(samples) == 0 {
+		return stats{}
+	}
+	sort.Float64s(samples)
+
+	mean, stdDev := stat.MeanStdDev(samples, nil)
+	var cv float64
+	if mean != 0 {
+		cv = stdDev / mean
+	}
+
+	return stats{
+		Average: mean,
+		StdDev:  stdDev,
+		CV:      cv,
+		P50:     stat.Quantile(0.50, stat.Empirical, samples, nil),
+		P90:     stat.Quantile(0.90, stat.Empirical, samples, nil),
+		P95:     stat.Quantile(0.95, stat.Empirical, samples, nil),
+		P99:     stat.Quantile(0.99, stat.Empirical, samples, nil),
+		P999:    stat.Quantile(0.999, stat.Empirical, samples, nil),
+	}
+}
+
+// values returns s's fields in the order latencyHeaders names them.
+func (s stats) values() []float64 {
+	return []float64{s.Average, s.StdDev, s.CV, s.P50, s.P90, s.P95, s.P99, s.P999}
+}
+
+// formatFloat renders v with decimal as its decimal separator, so output
+// can be fed straight into tools (e.g. a locale-swapped spreadsheet) that
+// expect "," instead of the Go-native ".".
+func formatFloat(v float64, decimal string) string {
+	s := fmt.Sprintf("%f", v)
+	if decimal == "," {
+		s = strings.Replace(s, ".", ",", 1)
+	}
+	return s
+}
+
+// statsColumns formats s in the column order used by latencyHeaders above.
+func statsColumns(s stats, decimal string) []string {
+	vals := s.values()
+	cols := make([]string, 0, len(vals))
+	for _, v := range vals {
+		cols = append(cols, formatFloat(v, decimal))
+	}
+	return cols
+}
+
+// recordMetrics flattens r's stats into a "<statheader>_<metric>" -> value
+// map, using the same naming buildHeader uses for CSV columns, so the
+// json/jsonl/influx writers don't have to hand-enumerate every field.
+func recordMetrics(r Record) map[string]float64 {
+	statsByMetric := map[string]stats{
+		"slow_rt":  r.slowRt,
+		"slow_wt":  r.slowWt,
+		"slow_rtt": r.slowRtt,
+		"fast_rt":  r.fastRt,
+		"fast_wt":  r.fastWt,
+		"fast_rtt": r.fastRtt,
+	}
+	m := make(map[string]float64, len(latencyMetrics)*len(latencyHeaders))
+	for _, metric := range latencyMetrics {
+		vals := statsByMetric[metric].values()
+		for i, lh := range latencyHeaders {
+			m[fmt.Sprintf("%s_%s", lh, metric)] = vals[i]
+		}
+	}
+	return m
 }
 
 type Record struct {
-	alg            string
-	fastInt        time.Duration
-	slowInt        time.Duration
-	totRequests    int
-	slowPercent    int
-	averageSlowRt  float64
-	averageSlowWt  float64
-	averageSlowRtt float64
-	averageFastRt  float64
-	averageFastWt  float64
-	averageFastRtt float64
+	alg         string
+	fastInt     time.Duration
+	slowInt     time.Duration
+	totRequests int
+	slowPercent int
+	modTime     time.Time
+	slowRt      stats
+	slowWt      stats
+	slowRtt     stats
+	fastRt      stats
+	fastWt      stats
+	fastRtt     stats
+}
+
+// OutputWriter serializes analyzed Records in one output format.
+// WriteHeader is called once before any WriteRecord; Close flushes and
+// releases whatever resources the writer holds, and must be called
+// exactly once, after the last WriteRecord.
+type OutputWriter interface {
+	WriteHeader() error
+	WriteRecord(r Record) error
+	Close() error
+}
+
+// parseDecimal resolves the -decimal flag value. An empty string defaults
+// to ".".
+func parseDecimal(s string) (string, error) {
+	switch s {
+	case "", ".":
+		return ".", nil
+	case ",":
+		return ",", nil
+	default:
+		return "", fmt.Errorf("unsupported decimal separator: %q", s)
+	}
+}
+
+// newOutputWriter builds the OutputWriter for format, writing to w. decimal
+// only affects the csv writer; json/jsonl always encode floats the
+// encoding/json way, and influx line protocol always uses ".".
+func newOutputWriter(format string, w io.Writer, decimal string) (OutputWriter, error) {
+	switch format {
+	case "", "csv":
+		return newCSVOutputWriter(w, decimal), nil
+	case "json":
+		return newJSONOutputWriter(w), nil
+	case "jsonl":
+		return newJSONLOutputWriter(w), nil
+	case "influx":
+		return newInfluxOutputWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported output format: %q", format)
+	}
+}
+
+// csvOutputWriter is the original semicolon-separated format.
+type csvOutputWriter struct {
+	w       *csv.Writer
+	decimal string
+}
+
+func newCSVOutputWriter(w io.Writer, decimal string) *csvOutputWriter {
+	cw := csv.NewWriter(w)
+	cw.Comma = ';'
+	return &csvOutputWriter{w: cw, decimal: decimal}
+}
+
+func (c *csvOutputWriter) WriteHeader() error {
+	return c.w.Write(header)
+}
+
+func (c *csvOutputWriter) WriteRecord(r Record) error {
+	row := []string{
+		r.alg,
+		r.fastInt.String(),
+		r.slowInt.String(),
+		fmt.Sprintf("%d", r.totRequests),
+		fmt.Sprintf("%d", r.slowPercent),
+	}
+	for _, s := range []stats{r.slowRt, r.slowWt, r.slowRtt, r.fastRt, r.fastWt, r.fastRtt} {
+		row = append(row, statsColumns(s, c.decimal)...)
+	}
+	return c.w.Write(row)
+}
+
+func (c *csvOutputWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonRecordPayload is the shape both the json and jsonl writers encode a
+// Record as.
+type jsonRecordPayload struct {
+	Alg         string             `json:"alg"`
+	FastInt     string             `json:"fast_int"`
+	SlowInt     string             `json:"slow_int"`
+	TotRequests int                `json:"tot_requests"`
+	SlowPercent int                `json:"slow_percent"`
+	Metrics     map[string]float64 `json:"metrics"`
+}
+
+func newJSONRecordPayload(r Record) jsonRecordPayload {
+	return jsonRecordPayload{
+		Alg:         r.alg,
+		FastInt:     r.fastInt.String(),
+		SlowInt:     r.slowInt.String(),
+		TotRequests: r.totRequests,
+		SlowPercent: r.slowPercent,
+		Metrics:     recordMetrics(r),
+	}
+}
+
+// jsonOutputWriter buffers every Record and writes them as a single JSON
+// array on Close, since a streamed array can't be appended to once its
+// closing bracket is written.
+type jsonOutputWriter struct {
+	w       io.Writer
+	records []jsonRecordPayload
+}
+
+func newJSONOutputWriter(w io.Writer) *jsonOutputWriter {
+	return &jsonOutputWriter{w: w}
+}
+
+func (j *jsonOutputWriter) WriteHeader() error { return nil }
+
+func (j *jsonOutputWriter) WriteRecord(r Record) error {
+	j.records = append(j.records, newJSONRecordPayload(r))
+	return nil
+}
+
+func (j *jsonOutputWriter) Close() error {
+	return json.NewEncoder(j.w).Encode(j.records)
+}
+
+// jsonlOutputWriter writes one JSON object per Record and flushes it
+// immediately, so a consumer tailing the output file sees results as soon
+// as each input file finishes, rather than only once every file is done.
+type jsonlOutputWriter struct {
+	enc *json.Encoder
 }
 
+func newJSONLOutputWriter(w io.Writer) *jsonlOutputWriter {
+	return &jsonlOutputWriter{enc: json.NewEncoder(w)}
+}
+
+func (j *jsonlOutputWriter) WriteHeader() error { return nil }
+
+func (j *jsonlOutputWriter) WriteRecord(r Record) error {
+	return j.enc.Encode(newJSONRecordPayload(r))
+}
+
+func (j *jsonlOutputWriter) Close() error { return nil }
+
+// influxOutputWriter writes InfluxDB line protocol, tagging each point
+// with alg/slow_percent and fielding only the per-metric averages (the
+// full percentile/stddev breakdown stays CSV/JSON-only); the point's
+// timestamp is the source file's modification time.
+type influxOutputWriter struct {
+	w io.Writer
+}
+
+func newInfluxOutputWriter(w io.Writer) *influxOutputWriter {
+	return &influxOutputWriter{w: w}
+}
+
+func (i *influxOutputWriter) WriteHeader() error { return nil }
+
+func (i *influxOutputWriter) WriteRecord(r Record) error {
+	fields := []string{
+		fmt.Sprintf("avg_slow_rt=%f", r.slowRt.Average),
+		fmt.Sprintf("avg_slow_wt=%f", r.slowWt.Average),
+		fmt.Sprintf("avg_slow_rtt=%f", r.slowRtt.Average),
+		fmt.Sprintf("avg_fast_rt=%f", r.fastRt.Average),
+		fmt.Sprintf("avg_fast_wt=%f", r.fastWt.Average),
+		fmt.Sprintf("avg_fast_rtt=%f", r.fastRtt.Average),
+	}
+	_, err := fmt.Fprintf(i.w, "pbench,alg=%s,slow_percent=%d %s %d\n",
+		r.alg, r.slowPercent, strings.Join(fields, ","), r.modTime.UnixNano())
+	return err
+}
+
+func (i *influxOutputWriter) Close() error { return nil }
+
 func main() {
 	flag.Parse()
 	c := Config{
 		inputDirectory: *inputDirectory,
 		outputFile:     *outputFile,
 		concurrency:    *concurrency,
+		useHistogram:   *useHistogram,
+		format:         *format,
+		decimal:        *decimal,
 	}
 	if err := run(c); err != nil && !errors.Is(err, context.Canceled) {
 		log.Fatal(err)
@@ -76,6 +373,11 @@ func main() {
 }
 
 func run(c Config) error {
+	decimal, err := parseDecimal(c.decimal)
+	if err != nil {
+		return err
+	}
+
 	directory, err := os.ReadDir(c.inputDirectory)
 	if err != nil {
 		return err
@@ -112,7 +414,7 @@ func run(c Config) error {
 		for i := 0; i < int(c.concurrency); i++ {
 			g.Go(func() error {
 				for file := range files {
-					if err := process(ctx, file, records); err != nil {
+					if err := process(ctx, file, c.useHistogram, records); err != nil {
 						log.Print(err)
 					}
 				}
@@ -139,38 +441,64 @@ func run(c Config) error {
 		} else {
 			writer = os.Stdout
 		}
-		csvWriter := csv.NewWriter(writer)
-		csvWriter.Comma = ';'
-		defer csvWriter.Flush()
 
-		csvWriter.Write(header)
+		out, err := newOutputWriter(c.format, writer, decimal)
+		if err != nil {
+			return err
+		}
+		if err := out.WriteHeader(); err != nil {
+			return err
+		}
+
 		for record := range records {
-			row := []string{
-				record.alg,
-				record.fastInt.String(),
-				record.slowInt.String(),
-				fmt.Sprintf("%d", record.totRequests),
-				fmt.Sprintf("%d", record.slowPercent),
-				strings.Replace(fmt.Sprintf("%f", record.averageSlowRt), ".", ",", 1),
-				strings.Replace(fmt.Sprintf("%f", record.averageSlowWt), ".", ",", 1),
-				strings.Replace(fmt.Sprintf("%f", record.averageSlowRtt), ".", ",", 1),
-				strings.Replace(fmt.Sprintf("%f", record.averageFastRt), ".", ",", 1),
-				strings.Replace(fmt.Sprintf("%f", record.averageFastWt), ".", ",", 1),
-				strings.Replace(fmt.Sprintf("%f", record.averageFastRtt), ".", ",", 1),
-			}
-			if err := csvWriter.Write(row); err != nil {
+			if err := out.WriteRecord(record); err != nil {
 				log.Print(err)
 			}
 		}
 
-		return nil
+		return out.Close()
 
 	})
 
 	return g.Wait()
 }
 
-func process(ctx context.Context, file string, records chan<- Record) error {
+// latencyAccumulator collects rt/wt/rtt samples for a single file and
+// summarizes them into six stats once every line has been seen. sliceAccumulator
+// and histogramAccumulator implement this the exact and the approximate,
+// O(1)-memory way respectively; process picks one based on useHistogram.
+type latencyAccumulator interface {
+	addSlow(rt, wt, rtt int64)
+	addFast(rt, wt, rtt int64)
+	stats() (slowRt, slowWt, slowRtt, fastRt, fastWt, fastRtt stats)
+}
+
+// sliceAccumulator holds every sample in memory and computes exact
+// quantiles; unsuitable for files with more samples than fit comfortably
+// in RAM.
+type sliceAccumulator struct {
+	slowRt, slowWt, slowRtt []float64
+	fastRt, fastWt, fastRtt []float64
+}
+
+func (a *sliceAccumulator) addSlow(rt, wt, rtt int64) {
+	a.slowRt = append(a.slowRt, float64(rt))
+	a.slowWt = append(a.slowWt, float64(wt))
+	a.slowRtt = append(a.slowRtt, float64(rtt))
+}
+
+func (a *sliceAccumulator) addFast(rt, wt, rtt int64) {
+	a.fastRt = append(a.fastRt, float64(rt))
+	a.fastWt = append(a.fastWt, float64(wt))
+	a.fastRtt = append(a.fastRtt, float64(rtt))
+}
+
+func (a *sliceAccumulator) stats() (slowRt, slowWt, slowRtt, fastRt, fastWt, fastRtt stats) {
+	return newStats(a.slowRt), newStats(a.slowWt), newStats(a.slowRtt),
+		newStats(a.fastRt), newStats(a.fastWt), newStats(a.fastRtt)
+}
+
+func process(ctx context.Context, file string, useHistogram bool, records chan<- Record) error {
 
 	alg, fastInt, slowInt, nRequests, slowLoad, err := parseFilename(filepath.Base(file))
 
@@ -184,7 +512,17 @@ func process(ctx context.Context, file string, records chan<- Record) error {
 	}
 	defer f.Close()
 
-	var totSlowRt, totFastRt, totFastRtt, totFastWt, totSlowRtt, totSlowWt, slowCount, fastCount int64 = 0, 0, 0, 0, 0, 0, 0, 0
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("cannot stat %q: %v", file, err)
+	}
+
+	var acc latencyAccumulator
+	if useHistogram {
+		acc = &histogramAccumulator{}
+	} else {
+		acc = &sliceAccumulator{}
+	}
 
 	r := bufio.NewScanner(f)
 
@@ -202,40 +540,29 @@ func process(ctx context.Context, file string, records chan<- Record) error {
 			}
 
 			if rqType == 0 {
-				slowCount += 1
-				totSlowRt += rt
-				totSlowRtt += rtt
-				totSlowWt += wt
+				acc.addSlow(rt, wt, rtt)
 			} else if rqType == 1 {
-				fastCount += 1
-				totFastRt += rt
-				totFastRtt += rtt
-				totFastWt += wt
+				acc.addFast(rt, wt, rtt)
 			}
 		}
 
 	}
 
-	avgSlowRt := float64(totSlowRt) / float64(slowCount)
-	avgSlowWt := float64(totSlowWt) / float64(slowCount)
-	avgSlowRtt := float64(totSlowRtt) / float64(slowCount)
-
-	avgFastRt := float64(totFastRt) / float64(fastCount)
-	avgFastWt := float64(totFastWt) / float64(fastCount)
-	avgFastRtt := float64(totFastRtt) / float64(fastCount)
+	slowRt, slowWt, slowRtt, fastRt, fastWt, fastRtt := acc.stats()
 
 	records <- Record{
-		alg:            alg,
-		fastInt:        fastInt,
-		slowInt:        slowInt,
-		totRequests:    nRequests,
-		slowPercent:    slowLoad,
-		averageSlowRt:  avgSlowRt,
-		averageSlowWt:  avgSlowWt,
-		averageSlowRtt: avgSlowRtt,
-		averageFastRt:  avgFastRt,
-		averageFastWt:  avgFastWt,
-		averageFastRtt: avgFastRtt,
+		alg:         alg,
+		fastInt:     fastInt,
+		slowInt:     slowInt,
+		totRequests: nRequests,
+		slowPercent: slowLoad,
+		modTime:     info.ModTime(),
+		slowRt:      slowRt,
+		slowWt:      slowWt,
+		slowRtt:     slowRtt,
+		fastRt:      fastRt,
+		fastWt:      fastWt,
+		fastRtt:     fastRtt,
 	}
 	return nil
 }
@@ -299,7 +626,7 @@ func parseFilename(fname string) (string, time.Duration, time.Duration, int, int
 
 	alg := strings.ToLower(parts[0])
 
-	if alg != "fcfs" && alg != "drr" {
+	if alg != "fcfs" && alg != "drr" && alg != "wfq" && alg != "pq" && alg != "sjf" {
 		return "", 0, 0, 0, 0, fmt.Errorf("unknown algoritm %q", alg)
 
 	}
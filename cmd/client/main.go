@@ -11,22 +11,31 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/alarmfox/tesi/internal/pbench"
 	"golang.org/x/sync/errgroup"
 )
 
 var (
-	serverAddress = flag.String("server-address", "127.0.0.1:8000", "Address for TCP server")
-	scheduler     = flag.String("scheduler", "", "Scheduling algorithm used by the server")
-	inputFile     = flag.String("input-file", "workload.json", "File path containing workload")
-	outputFile    = flag.String("output-file", "", "File path to write result")
+	serverAddress    = flag.String("server-address", "127.0.0.1:8000", "Address for TCP server")
+	scheduler        = flag.String("scheduler", "", "Scheduling algorithm used by the server")
+	inputFile        = flag.String("input-file", "workload.json", "File path containing workload")
+	outputFile       = flag.String("output-file", "", "File path to write result")
+	maxIdleConns     = flag.Int("max-idle-conns", 100, "Maximum number of idle connections kept open to the server")
+	maxOpenConn      = flag.Int("max-open-conns", 0, "Maximum number of open connections to the server (0 means unlimited)")
+	codecName        = flag.String("codec", "json", "Wire codec to use: json or proto")
+	samplesDir       = flag.String("samples-directory", "", "If set, write one .samples file per workload block with its raw per-request latencies")
+	progressInterval = flag.Duration("progress-interval", 0, "If set, log benchmark progress at this interval (0 disables progress reporting)")
+	progressFormat   = flag.String("progress-format", "text", "Progress reporter output format: text or json")
 )
 
 var (
 	header = []string{
 		"sched",
+		"codec",
 		"fast_rate",
 		"slow_rate",
 		"tot_requests",
@@ -36,26 +45,68 @@ var (
 		"avg_slow_rt",
 		"min_slow_rt",
 		"max_slow_rt",
+		"stddev_slow_rt",
+		"cv_slow_rt",
+		"p50_slow_rt",
+		"p90_slow_rt",
+		"p95_slow_rt",
+		"p99_slow_rt",
+		"p999_slow_rt",
 
 		"avg_slow_wt",
 		"min_slow_wt",
 		"max_slow_wt",
+		"stddev_slow_wt",
+		"cv_slow_wt",
+		"p50_slow_wt",
+		"p90_slow_wt",
+		"p95_slow_wt",
+		"p99_slow_wt",
+		"p999_slow_wt",
 
 		"avg_slow_rtt",
 		"min_slow_rtt",
 		"max_slow_rtt",
+		"stddev_slow_rtt",
+		"cv_slow_rtt",
+		"p50_slow_rtt",
+		"p90_slow_rtt",
+		"p95_slow_rtt",
+		"p99_slow_rtt",
+		"p999_slow_rtt",
 
 		"avg_fast_rt",
 		"min_fast_rt",
 		"max_fast_rt",
+		"stddev_fast_rt",
+		"cv_fast_rt",
+		"p50_fast_rt",
+		"p90_fast_rt",
+		"p95_fast_rt",
+		"p99_fast_rt",
+		"p999_fast_rt",
 
 		"avg_fast_wt",
 		"min_fast_wt",
 		"max_fast_wt",
+		"stddev_fast_wt",
+		"cv_fast_wt",
+		"p50_fast_wt",
+		"p90_fast_wt",
+		"p95_fast_wt",
+		"p99_fast_wt",
+		"p999_fast_wt",
 
 		"avg_fast_rtt",
 		"min_fast_rtt",
 		"max_fast_rtt",
+		"stddev_fast_rtt",
+		"cv_fast_rtt",
+		"p50_fast_rtt",
+		"p90_fast_rtt",
+		"p95_fast_rtt",
+		"p99_fast_rtt",
+		"p999_fast_rtt",
 
 		"avg_memory",
 		"min_memory",
@@ -68,14 +119,47 @@ var (
 		"avg_cpu",
 		"min_cpu",
 		"max_cpu",
+
+		"avg_slow_queue_depth",
+		"min_slow_queue_depth",
+		"max_slow_queue_depth",
+
+		"avg_fast_queue_depth",
+		"min_fast_queue_depth",
+		"max_fast_queue_depth",
+
+		"slow_drops",
+		"fast_drops",
+
+		"avg_load1",
+		"min_load1",
+		"max_load1",
+
+		"avg_mem_percent",
+		"min_mem_percent",
+		"max_mem_percent",
+
+		"avg_cpu_percent_avg",
+		"min_cpu_percent_avg",
+		"max_cpu_percent_avg",
+
+		"avg_cpu_percent_max",
+		"min_cpu_percent_max",
+		"max_cpu_percent_max",
 	}
 )
 
 type Config struct {
-	algorithm  string
-	addr       string
-	outputFile string
-	inputFile  string
+	algorithm        string
+	addr             string
+	outputFile       string
+	inputFile        string
+	maxIdleConns     int
+	maxOpenConn      int
+	codec            string
+	samplesDir       string
+	progressInterval time.Duration
+	progressFormat   string
 }
 
 type block struct {
@@ -89,10 +173,16 @@ func main() {
 	flag.Parse()
 
 	c := Config{
-		addr:       *serverAddress,
-		outputFile: *outputFile,
-		algorithm:  *scheduler,
-		inputFile:  *inputFile,
+		addr:             *serverAddress,
+		outputFile:       *outputFile,
+		algorithm:        *scheduler,
+		inputFile:        *inputFile,
+		maxIdleConns:     *maxIdleConns,
+		maxOpenConn:      *maxOpenConn,
+		codec:            *codecName,
+		samplesDir:       *samplesDir,
+		progressInterval: *progressInterval,
+		progressFormat:   *progressFormat,
 	}
 	if err := run(c); err != nil && !errors.Is(err, context.Canceled) {
 		log.Fatal(err)
@@ -104,6 +194,34 @@ type record struct {
 	request pbench.BenchConfig
 }
 
+// latencyColumns formats the min/avg/max/stddev/cv/percentile fields a
+// benchResult carries for a single latency metric, in the column order
+// used by header above.
+func latencyColumns(avg, min, max, stddev, cv, p50, p90, p95, p99, p999 float64) []string {
+	return []string{
+		fmt.Sprintf("%f", avg),
+		fmt.Sprintf("%f", min),
+		fmt.Sprintf("%f", max),
+		fmt.Sprintf("%f", stddev),
+		fmt.Sprintf("%f", cv),
+		fmt.Sprintf("%f", p50),
+		fmt.Sprintf("%f", p90),
+		fmt.Sprintf("%f", p95),
+		fmt.Sprintf("%f", p99),
+		fmt.Sprintf("%f", p999),
+	}
+}
+
+// samplesFilePath builds a co-located path for the raw per-request samples
+// of workload block b, named so cmd/analyze's parseFilename can recover the
+// block's parameters straight from the filename, the same way it already
+// does for hand-produced log files.
+func samplesFilePath(dir, algorithm string, b block) string {
+	fastInt := time.Duration(float64(time.Second) / b.FastRate)
+	slowInt := time.Duration(float64(time.Second) / b.SlowRate)
+	return filepath.Join(dir, fmt.Sprintf("%s_%s_%s_%d_%d.samples", algorithm, fastInt, slowInt, b.TotRequests, b.SlowPercent))
+}
+
 func run(c Config) error {
 	ctx, canc := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer canc()
@@ -115,6 +233,24 @@ func run(c Config) error {
 		return err
 	}
 
+	if c.samplesDir != "" {
+		if err := os.MkdirAll(c.samplesDir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	var reporter pbench.Reporter
+	if c.progressInterval > 0 {
+		switch c.progressFormat {
+		case "text":
+			reporter = pbench.NewLogReporter(os.Stderr)
+		case "json":
+			reporter = pbench.NewJSONReporter(os.Stderr)
+		default:
+			return fmt.Errorf("unsupported progress format: %q", c.progressFormat)
+		}
+	}
+
 	records := make(chan record, len(benches))
 	g.Go(func() error {
 		defer close(records)
@@ -129,6 +265,14 @@ func run(c Config) error {
 				SlowRequestLoad: benches[i].SlowPercent,
 				SlowRate:        benches[i].SlowRate,
 				FastRate:        benches[i].FastRate,
+				MaxIdleConns:    c.maxIdleConns,
+				MaxOpenConn:     c.maxOpenConn,
+				Codec:           c.codec,
+				Reporter:        reporter,
+				ReportInterval:  c.progressInterval,
+			}
+			if c.samplesDir != "" {
+				cfg.SamplesFile = samplesFilePath(c.samplesDir, c.algorithm, benches[i])
 			}
 			r, err = pbench.Bench(ctx, cfg)
 			if err != nil {
@@ -167,36 +311,38 @@ func run(c Config) error {
 		for record := range records {
 			row := []string{
 				c.algorithm,
+				c.codec,
 				fmt.Sprintf("%d", int(record.request.FastRate)),
 				fmt.Sprintf("%d", int(record.request.SlowRate)),
 				fmt.Sprintf("%d", record.request.TotRequests),
 				fmt.Sprintf("%d", record.request.SlowRequestLoad),
 				fmt.Sprintf("%f", record.result.Rps),
-
-				fmt.Sprintf("%f", record.result.SlowRt.Average),
-				fmt.Sprintf("%f", record.result.SlowRt.Min),
-				fmt.Sprintf("%f", record.result.SlowRt.Max),
-
-				fmt.Sprintf("%f", record.result.SlowWt.Average),
-				fmt.Sprintf("%f", record.result.SlowWt.Min),
-				fmt.Sprintf("%f", record.result.SlowWt.Max),
-
-				fmt.Sprintf("%f", record.result.SlowRtt.Average),
-				fmt.Sprintf("%f", record.result.SlowRtt.Min),
-				fmt.Sprintf("%f", record.result.SlowRtt.Max),
-
-				fmt.Sprintf("%f", record.result.FastRt.Average),
-				fmt.Sprintf("%f", record.result.FastRt.Min),
-				fmt.Sprintf("%f", record.result.FastRt.Max),
-
-				fmt.Sprintf("%f", record.result.FastWt.Average),
-				fmt.Sprintf("%f", record.result.FastWt.Min),
-				fmt.Sprintf("%f", record.result.FastWt.Max),
-
-				fmt.Sprintf("%f", record.result.FastRtt.Average),
-				fmt.Sprintf("%f", record.result.FastRtt.Min),
-				fmt.Sprintf("%f", record.result.FastRtt.Max),
-
+			}
+			row = append(row, latencyColumns(
+				record.result.SlowRt.Average, record.result.SlowRt.Min, record.result.SlowRt.Max,
+				record.result.SlowRt.StdDev, record.result.SlowRt.CV,
+				record.result.SlowRt.P50, record.result.SlowRt.P90, record.result.SlowRt.P95, record.result.SlowRt.P99, record.result.SlowRt.P999)...)
+			row = append(row, latencyColumns(
+				record.result.SlowWt.Average, record.result.SlowWt.Min, record.result.SlowWt.Max,
+				record.result.SlowWt.StdDev, record.result.SlowWt.CV,
+				record.result.SlowWt.P50, record.result.SlowWt.P90, record.result.SlowWt.P95, record.result.SlowWt.P99, record.result.SlowWt.P999)...)
+			row = append(row, latencyColumns(
+				record.result.SlowRtt.Average, record.result.SlowRtt.Min, record.result.SlowRtt.Max,
+				record.result.SlowRtt.StdDev, record.result.SlowRtt.CV,
+				record.result.SlowRtt.P50, record.result.SlowRtt.P90, record.result.SlowRtt.P95, record.result.SlowRtt.P99, record.result.SlowRtt.P999)...)
+			row = append(row, latencyColumns(
+				record.result.FastRt.Average, record.result.FastRt.Min, record.result.FastRt.Max,
+				record.result.FastRt.StdDev, record.result.FastRt.CV,
+				record.result.FastRt.P50, record.result.FastRt.P90, record.result.FastRt.P95, record.result.FastRt.P99, record.result.FastRt.P999)...)
+			row = append(row, latencyColumns(
+				record.result.FastWt.Average, record.result.FastWt.Min, record.result.FastWt.Max,
+				record.result.FastWt.StdDev, record.result.FastWt.CV,
+				record.result.FastWt.P50, record.result.FastWt.P90, record.result.FastWt.P95, record.result.FastWt.P99, record.result.FastWt.P999)...)
+			row = append(row, latencyColumns(
+				record.result.FastRtt.Average, record.result.FastRtt.Min, record.result.FastRtt.Max,
+				record.result.FastRtt.StdDev, record.result.FastRtt.CV,
+				record.result.FastRtt.P50, record.result.FastRtt.P90, record.result.FastRtt.P95, record.result.FastRtt.P99, record.result.FastRtt.P999)...)
+			row = append(row, []string{
 				fmt.Sprintf("%f", record.result.Memory.Average),
 				fmt.Sprintf("%f", record.result.Memory.Min),
 				fmt.Sprintf("%f", record.result.Memory.Max),
@@ -208,7 +354,34 @@ func run(c Config) error {
 				fmt.Sprintf("%f", record.result.CPU.Average),
 				fmt.Sprintf("%f", record.result.CPU.Min),
 				fmt.Sprintf("%f", record.result.CPU.Max),
-			}
+
+				fmt.Sprintf("%f", record.result.SlowQueueDepth.Average),
+				fmt.Sprintf("%f", record.result.SlowQueueDepth.Min),
+				fmt.Sprintf("%f", record.result.SlowQueueDepth.Max),
+
+				fmt.Sprintf("%f", record.result.FastQueueDepth.Average),
+				fmt.Sprintf("%f", record.result.FastQueueDepth.Min),
+				fmt.Sprintf("%f", record.result.FastQueueDepth.Max),
+
+				fmt.Sprintf("%d", record.result.SlowDrops),
+				fmt.Sprintf("%d", record.result.FastDrops),
+
+				fmt.Sprintf("%f", record.result.Load1.Average),
+				fmt.Sprintf("%f", record.result.Load1.Min),
+				fmt.Sprintf("%f", record.result.Load1.Max),
+
+				fmt.Sprintf("%f", record.result.MemPercent.Average),
+				fmt.Sprintf("%f", record.result.MemPercent.Min),
+				fmt.Sprintf("%f", record.result.MemPercent.Max),
+
+				fmt.Sprintf("%f", record.result.CPUPercentAvg.Average),
+				fmt.Sprintf("%f", record.result.CPUPercentAvg.Min),
+				fmt.Sprintf("%f", record.result.CPUPercentAvg.Max),
+
+				fmt.Sprintf("%f", record.result.CPUPercentMax.Average),
+				fmt.Sprintf("%f", record.result.CPUPercentMax.Min),
+				fmt.Sprintf("%f", record.result.CPUPercentMax.Max),
+			}...)
 			if err := csvWriter.Write(row); err != nil {
 				log.Print(err)
 			}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/alarmfox/tesi/internal/pbench"
+)
+
+// backendList collects repeated -backend flags into a slice.
+type backendList []string
+
+func (b *backendList) String() string {
+	return strings.Join(*b, ",")
+}
+
+func (b *backendList) Set(value string) error {
+	*b = append(*b, value)
+	return nil
+}
+
+var (
+	listenAddress = flag.String("listen-address", "127.0.0.1:9000", "Listen address for the coordinator")
+	policyName    = flag.String("policy", "round-robin", "Backend selection policy: round-robin, least-outstanding or consistent-hash")
+	codecName     = flag.String("codec", "json", "Wire codec to use: json or proto")
+	maxIdleConns  = flag.Int("max-idle-conns", 100, "Maximum number of idle connections kept open to each backend")
+	maxOpenConn   = flag.Int("max-open-conns", 0, "Maximum number of open connections to each backend (0 means unlimited)")
+	backends      backendList
+)
+
+type Config struct {
+	listenAddress string
+	policy        string
+	codec         string
+	maxIdleConns  int
+	maxOpenConn   int
+	backends      []string
+}
+
+func main() {
+	flag.Var(&backends, "backend", "Address of a backend pbench.Server (repeatable)")
+	flag.Parse()
+
+	c := Config{
+		listenAddress: *listenAddress,
+		policy:        *policyName,
+		codec:         *codecName,
+		maxIdleConns:  *maxIdleConns,
+		maxOpenConn:   *maxOpenConn,
+		backends:      backends,
+	}
+
+	if err := run(c); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+}
+
+func run(c Config) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	policy, err := pbench.ParseBackendPolicy(c.policy)
+	if err != nil {
+		return err
+	}
+
+	codec, err := pbench.NewCodec(c.codec)
+	if err != nil {
+		return err
+	}
+
+	coordinator, err := pbench.NewCoordinator(c.backends, policy, codec, c.maxIdleConns, c.maxOpenConn)
+	if err != nil {
+		return err
+	}
+
+	return coordinator.Start(ctx, c.listenAddress)
+}
@@ -2,41 +2,68 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"flag"
-	"fmt"
 	"log"
 	"os/signal"
-	"runtime"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/alarmfox/tesi/internal/pbench"
-	"github.com/shirou/gopsutil/load"
-	"golang.org/x/sync/errgroup"
+	"github.com/alarmfox/tesi/internal/pbench/sysstats"
 )
 
 var (
-	listenAddress = flag.String("listen-address", "127.0.0.1:8000", "Listen address for TCP server")
-	scheduler     = flag.String("scheduler", "", "Scheduler algorithm to be used")
-	slowTime      = flag.Duration("slow-time", time.Millisecond, "Time to sleep in slow requests")
+	listenAddress    = flag.String("listen-address", "127.0.0.1:8000", "Listen address for TCP server")
+	scheduler        = flag.String("scheduler", "", "Scheduler algorithm to be used")
+	slowTime         = flag.Duration("slow-time", time.Millisecond, "Time to sleep in slow requests")
+	bufferSize       = flag.Int("buffer-size", pbench.DefaultBufferSize, "Number of positions in the simulated backing buffer")
+	wfqSlowCost      = flag.Float64("wfq-slow-cost", 10, "Estimated cost of a slow request used by the wfq scheduler")
+	codecName        = flag.String("codec", "json", "Wire codec to use: json or proto")
+	queueCapacity    = flag.Int("queue-capacity", 0, "Capacity of each priority queue (0 means no buffer: a request is admitted only once the scheduler takes it); only applies to the memory queue backend")
+	overflowName     = flag.String("overflow-policy", "block", "What to do when a priority queue is full: block, drop-newest, drop-oldest or reject-with-error; only applies to the memory queue backend")
+	sysstatsInterval = flag.Duration("sysstats-interval", time.Second, "Interval between host telemetry samples attached to responses (0 disables telemetry)")
+	queueBackend     = flag.String("queue-backend", "memory", "Priority queue backend: memory, bolt or redis")
+	queueDataDir     = flag.String("queue-data-dir", "", "Directory for the bolt queue backend's database files")
+	queueRedisAddr   = flag.String("queue-redis-address", "127.0.0.1:6379", "Address of the redis instance backing the redis queue backend")
+	statsAddress     = flag.String("stats-address", "", "Listen address for the /stats HTTP endpoint (empty disables it)")
 )
 
 type Config struct {
-	listenAddress string
-	scheduler     string
-	slowTime      time.Duration
+	listenAddress    string
+	scheduler        string
+	slowTime         time.Duration
+	bufferSize       int
+	wfqSlowCost      float64
+	codec            string
+	queueCapacity    int
+	overflowPolicy   string
+	sysstatsInterval time.Duration
+	queueBackend     string
+	queueDataDir     string
+	queueRedisAddr   string
+	statsAddress     string
 }
 
 func main() {
 	flag.Parse()
 
 	c := Config{
-		listenAddress: *listenAddress,
-		scheduler:     *scheduler,
-		slowTime:      *slowTime,
+		listenAddress:    *listenAddress,
+		scheduler:        *scheduler,
+		slowTime:         *slowTime,
+		bufferSize:       *bufferSize,
+		wfqSlowCost:      *wfqSlowCost,
+		codec:            *codecName,
+		queueCapacity:    *queueCapacity,
+		overflowPolicy:   *overflowName,
+		sysstatsInterval: *sysstatsInterval,
+		queueBackend:     *queueBackend,
+		queueDataDir:     *queueDataDir,
+		queueRedisAddr:   *queueRedisAddr,
+		statsAddress:     *statsAddress,
 	}
 
 	if err := run(c); err != nil && !errors.Is(err, context.Canceled) {
@@ -47,78 +74,68 @@ func main() {
 func run(c Config) error {
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
 
-	g, ctx := errgroup.WithContext(ctx)
+	policy, err := pbench.ParseOverflowPolicy(c.overflowPolicy)
+	if err != nil {
+		return err
+	}
 
-	g.Go(func() error {
-		<-ctx.Done()
-		cancel()
-		return ctx.Err()
-	})
+	queueCfg := pbench.JobQueueConfig{
+		Capacity:     c.queueCapacity,
+		Policy:       policy,
+		BoltPath:     filepath.Join(c.queueDataDir, "queue-high.db"),
+		RedisAddress: c.queueRedisAddr,
+	}
+	hiPrio, err := pbench.NewJobQueue(c.queueBackend, "high", queueCfg)
+	if err != nil {
+		return err
+	}
+	queueCfg.BoltPath = filepath.Join(c.queueDataDir, "queue-low.db")
+	loPrio, err := pbench.NewJobQueue(c.queueBackend, "low", queueCfg)
+	if err != nil {
+		return err
+	}
 
 	jobs := make(chan pbench.Job)
-	hiPrio := make(chan pbench.Job)
-	loPrio := make(chan pbench.Job)
-
-	defer close(loPrio)
-	defer close(hiPrio)
-
-	var isDRR bool
-	g.Go(func() error {
-
-		defer close(jobs)
-		switch strings.ToLower(c.scheduler) {
-		case "fcfs":
-			isDRR = false
-			scheduler := pbench.NewFCFS(hiPrio, jobs)
-			return scheduler.Start(ctx)
-		case "drr":
-			isDRR = true
-			scheduler, err := pbench.NewDRR(jobs)
-			if err != nil {
-				return err
-			}
-			scheduler.Input(3, hiPrio)
-			scheduler.Input(2, loPrio)
-			return scheduler.Start(ctx)
-		default:
-			return fmt.Errorf("unsupported scheduler: %q", c.scheduler)
-		}
-	})
 
-	g.Go(func() error {
-		server := pbench.NewServer(hiPrio, loPrio, isDRR)
-		return server.Start(ctx, c.listenAddress)
+	alg := strings.ToLower(c.scheduler)
+	scheduler, err := pbench.NewScheduler(alg, jobs, hiPrio, loPrio, pbench.SchedulerConfig{
+		WFQSlowCost: c.wfqSlowCost,
 	})
+	if err != nil {
+		return err
+	}
 
-	g.Go(func() error {
-		buffer := pbench.NewBuffer(c.slowTime)
-		var memory runtime.MemStats
-		for job := range jobs {
-			job.Response.RunningTs = time.Now()
-			switch job.Request {
-			case pbench.SlowRequest:
-				buffer.Slow()
-			case pbench.FastRequest:
-				buffer.Fast()
-			}
-			runtime.ReadMemStats(&memory)
-			job.Response.Memory = memory.Sys
-			job.Response.FinishedTs = time.Now()
-
-			if cpuAvg, err := load.Avg(); err != nil {
-				log.Print(err)
-			} else {
-				job.Response.CPU = cpuAvg.Load1
-			}
-
-			if err := json.NewEncoder(job.Client).Encode(job.Response); err != nil {
-				log.Printf("response: %v", err)
-			}
-
-		}
-		return nil
-	})
+	codec, err := pbench.NewCodec(c.codec)
+	if err != nil {
+		return err
+	}
+
+	var sampler *sysstats.Sampler
+	if c.sysstatsInterval > 0 {
+		sampler = sysstats.NewSampler(c.sysstatsInterval)
+		go sampler.Run(ctx)
+	}
+
+	server := pbench.NewServer(c.listenAddress, hiPrio, loPrio, pbench.IsDRRFamily(alg), codec, sampler)
+
+	var observer pbench.ResidenceObserver
+	if o, ok := scheduler.(pbench.ResidenceObserver); ok {
+		observer = o
+	}
+	worker := pbench.NewWorker(jobs, pbench.NewBuffer(c.bufferSize, c.slowTime), codec, observer)
+
+	// Registered worker-before-scheduler so that stopping happens in the
+	// order a drain actually needs: server first (stop accepting new
+	// connections), then scheduler (drain its queues and close jobs),
+	// then worker (drain jobs until that close is observed).
+	services := []pbench.Service{worker, scheduler, server}
+	if c.statsAddress != "" {
+		services = append(services, pbench.NewStatsServer(c.statsAddress, server.Stats))
+	}
+
+	supervisor := pbench.NewSupervisor(services...)
 
-	return g.Wait()
+	return supervisor.Run(ctx)
 }
@@ -0,0 +1,164 @@
+package pbench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrOverloaded is returned by PriorityQueue.Enqueue, and written back to
+// the client as Response.Error, when a queue is full and its
+// OverflowPolicy does not allow blocking until space frees up.
+var ErrOverloaded = errors.New("ErrOverloaded")
+
+// OverflowPolicy decides what a PriorityQueue does when Enqueue is called
+// on a full queue.
+type OverflowPolicy int
+
+const (
+	// Block makes Enqueue wait until the job fits, just like sending on an
+	// unbuffered channel. This is the original server behavior.
+	Block OverflowPolicy = iota
+	// DropNewest rejects the arriving job and leaves the queue untouched.
+	DropNewest
+	// DropOldest evicts the head of the queue to make room for the
+	// arriving job, which is then admitted.
+	DropOldest
+	// RejectWithError behaves like DropNewest; it is kept as a distinct
+	// value so operators can tell "never admit over capacity" apart from
+	// "shed the newest arrival" in configuration and logs.
+	RejectWithError
+)
+
+// ParseOverflowPolicy resolves the -overflow-policy flag value. An empty
+// string defaults to Block.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "", "block":
+		return Block, nil
+	case "drop-newest":
+		return DropNewest, nil
+	case "drop-oldest":
+		return DropOldest, nil
+	case "reject-with-error":
+		return RejectWithError, nil
+	default:
+		return 0, fmt.Errorf("unknown overflow policy: %q", s)
+	}
+}
+
+// QueueStats is a point-in-time snapshot of a JobQueue. Admitted/Dropped
+// are only ever non-zero for an in-memory PriorityQueue: durable backends
+// apply no overflow policy of their own, so they report Depth alone.
+type QueueStats struct {
+	Depth    int
+	Admitted int
+	Dropped  int
+}
+
+// PriorityQueue is a bounded, in-memory FIFO of jobs for a single priority
+// class, and the JobQueue every scheduler used before durable backends
+// (see jobqueue.go) were introduced. Enqueue applies admission control
+// according to its OverflowPolicy; Dequeue feeds a scheduler.
+type PriorityQueue struct {
+	ch     chan Job
+	policy OverflowPolicy
+
+	mu       sync.Mutex
+	admitted int
+	dropped  int
+}
+
+// NewPriorityQueue creates a queue with the given capacity and overflow
+// policy. A capacity <= 0 gives an unbuffered queue, like make(chan Job):
+// with Block, Enqueue waits until a Dequeue takes the job; with any other
+// policy, a job is admitted only if a Dequeue is already waiting for one.
+func NewPriorityQueue(capacity int, policy OverflowPolicy) *PriorityQueue {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &PriorityQueue{
+		ch:     make(chan Job, capacity),
+		policy: policy,
+	}
+}
+
+// Enqueue applies the queue's overflow policy to j. It returns
+// ErrOverloaded when the caller should report the rejection to the client
+// instead of blocking the accept loop.
+func (q *PriorityQueue) Enqueue(j Job) error {
+	if q.policy == Block {
+		q.ch <- j
+		q.recordAdmitted()
+		return nil
+	}
+
+	select {
+	case q.ch <- j:
+		q.recordAdmitted()
+		return nil
+	default:
+	}
+
+	if q.policy == DropOldest {
+		select {
+		case <-q.ch:
+			q.recordDropped()
+		default:
+		}
+		select {
+		case q.ch <- j:
+			q.recordAdmitted()
+			return nil
+		default:
+		}
+	}
+
+	q.recordDropped()
+	return ErrOverloaded
+}
+
+// Dequeue blocks until a job is admitted or ctx is done.
+func (q *PriorityQueue) Dequeue(ctx context.Context) (Job, error) {
+	select {
+	case j := <-q.ch:
+		return j, nil
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	}
+}
+
+// Ack is a no-op: an in-memory queue has nothing left to forget once
+// Dequeue has handed a job off.
+func (q *PriorityQueue) Ack(id string) error {
+	return nil
+}
+
+// Len returns the current number of jobs waiting in the queue.
+func (q *PriorityQueue) Len() int {
+	return len(q.ch)
+}
+
+func (q *PriorityQueue) recordAdmitted() {
+	q.mu.Lock()
+	q.admitted++
+	q.mu.Unlock()
+}
+
+func (q *PriorityQueue) recordDropped() {
+	q.mu.Lock()
+	q.dropped++
+	q.mu.Unlock()
+}
+
+// Stats returns a snapshot of the queue's depth and admission counters.
+func (q *PriorityQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QueueStats{
+		Depth:    len(q.ch),
+		Admitted: q.admitted,
+		Dropped:  q.dropped,
+	}
+}
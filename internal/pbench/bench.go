@@ -1,12 +1,14 @@
 package pbench
 
 import (
+	"bufio"
 	"context"
-	"encoding/binary"
-	"encoding/json"
+	"fmt"
 	"log"
 	"math"
-	"net"
+	"math/rand"
+	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -24,25 +26,81 @@ type requestResult struct {
 	Memory        uint64
 	JobNumber     int
 	CPU           float64
+	QueueDepth    int
+	Overloaded    bool
+	// Load1, MemPercent, CPUPercentAvg and CPUPercentMax are the host
+	// telemetry attached to the response by the server's sysstats sampler.
+	// CPUPercentAvg/Max summarize Response.CPUPercent (one value per core)
+	// down to a single scalar, so system load and per-core saturation can
+	// be correlated with scheduling latency without carrying a slice
+	// through every downstream aggregate.
+	Load1         float64
+	MemPercent    float64
+	CPUPercentAvg float64
+	CPUPercentMax float64
 }
 
 type benchResult struct {
 	Average float64
 	Min     float64
 	Max     float64
+	StdDev  float64
+	CV      float64
+	P50     float64
+	P90     float64
+	P95     float64
+	P99     float64
+	P999    float64
+}
+
+// newBenchResult summarizes samples, which need not be sorted. It mutates
+// samples in place (sorting it) since every caller is done with its slice
+// right after.
+func newBenchResult(samples []float64) benchResult {
+	if len(samples) == 0 {
+		return benchResult{}
+	}
+	sort.Float64s(samples)
+
+	mean, stdDev := stat.MeanStdDev(samples, nil)
+	var cv float64
+	if mean != 0 {
+		cv = stdDev / mean
+	}
+
+	return benchResult{
+		Average: mean,
+		Min:     floats.Min(samples),
+		Max:     floats.Max(samples),
+		StdDev:  stdDev,
+		CV:      cv,
+		P50:     stat.Quantile(0.50, stat.Empirical, samples, nil),
+		P90:     stat.Quantile(0.90, stat.Empirical, samples, nil),
+		P95:     stat.Quantile(0.95, stat.Empirical, samples, nil),
+		P99:     stat.Quantile(0.99, stat.Empirical, samples, nil),
+		P999:    stat.Quantile(0.999, stat.Empirical, samples, nil),
+	}
 }
 
 type BenchResult struct {
-	SlowRt  benchResult
-	SlowWt  benchResult
-	SlowRtt benchResult
-	FastRt  benchResult
-	FastWt  benchResult
-	FastRtt benchResult
-	Memory  benchResult
-	Jobs    benchResult
-	CPU     benchResult
-	Rps     float64
+	SlowRt         benchResult
+	SlowWt         benchResult
+	SlowRtt        benchResult
+	FastRt         benchResult
+	FastWt         benchResult
+	FastRtt        benchResult
+	Memory         benchResult
+	Jobs           benchResult
+	CPU            benchResult
+	SlowQueueDepth benchResult
+	FastQueueDepth benchResult
+	SlowDrops      int
+	FastDrops      int
+	Rps            float64
+	Load1          benchResult
+	MemPercent     benchResult
+	CPUPercentAvg  benchResult
+	CPUPercentMax  benchResult
 }
 
 type BenchConfig struct {
@@ -52,17 +110,35 @@ type BenchConfig struct {
 	SlowRequestLoad int
 	SlowRate        float64
 	FastRate        float64
+	MaxIdleConns    int
+	MaxOpenConn     int
+	Codec           string
+	// SamplesFile, if set, receives one "type;residence;waiting;roundtrip"
+	// line (nanoseconds) per completed request, in the same format
+	// cmd/analyze already parses, so per-request latency distributions can
+	// be plotted instead of only the min/avg/max/percentiles in BenchResult.
+	SamplesFile string
+	// Reporter, if set together with a positive ReportInterval, receives a
+	// ProgressSnapshot every ReportInterval while the benchmark runs.
+	Reporter Reporter
+	// ReportInterval is the tick period for Reporter. Ignored if Reporter
+	// is nil or ReportInterval is not positive.
+	ReportInterval time.Duration
 }
 
 func Bench(ctx context.Context, c BenchConfig) (BenchResult, error) {
 
+	codec, err := NewCodec(c.Codec)
+	if err != nil {
+		return BenchResult{}, err
+	}
+
 	g, ctx := errgroup.WithContext(ctx)
 
 	requests := make(chan Request, c.TotRequests)
 	results := make(chan requestResult, c.TotRequests)
 	doneSendingJobs := make(chan struct{}, 2)
 	doneSendingResult := make(chan struct{})
-	terminationSignal := make(chan struct{})
 	defer close(doneSendingJobs)
 	defer close(doneSendingResult)
 
@@ -86,7 +162,26 @@ func Bench(ctx context.Context, c BenchConfig) (BenchResult, error) {
 		return nil
 	})
 
-	buffers := NewPool(func() []byte { b := make([]byte, 4); return b })
+	pool, err := createTcpConnPool(&tcpConfig{
+		Address:      c.ServerAddress,
+		MaxIdleConns: c.MaxIdleConns,
+		MaxOpenConn:  c.MaxOpenConn,
+	})
+	if err != nil {
+		return BenchResult{}, err
+	}
+	defer pool.close()
+
+	var samples *bufio.Writer
+	if c.SamplesFile != "" {
+		f, err := os.Create(c.SamplesFile)
+		if err != nil {
+			return BenchResult{}, err
+		}
+		defer f.Close()
+		samples = bufio.NewWriter(f)
+		defer samples.Flush()
+	}
 
 	g.Go(func() error {
 		wg := sync.WaitGroup{}
@@ -97,38 +192,36 @@ func Bench(ctx context.Context, c BenchConfig) (BenchResult, error) {
 				defer wg.Done()
 				start := time.Now()
 
-				conn, err := net.Dial("tcp4", c.ServerAddress)
+				tc, err := pool.get()
 				if err != nil {
 					log.Print(err)
 					return
 				}
-				defer conn.Close()
-
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					select {
-					case <-ctx.Done():
-						conn.SetDeadline(time.Now())
-					case <-terminationSignal:
-
-					}
-				}()
-				buffer := buffers.Get()
-				defer buffers.Put(buffer)
 
-				binary.BigEndian.PutUint32(buffer, uint32(r))
-
-				_, err = conn.Write(buffer)
-				if err != nil {
+				wr := wireRequest{
+					Type:    r,
+					Payload: rand.Intn(math.MaxInt32),
+					Offset:  rand.Intn(DefaultBufferSize),
+				}
+				if err := writeRequest(tc.conn, codec, wr); err != nil {
 					log.Print(err)
+					tc.conn.Close()
 					return
 				}
-				var response Response
-				if err := json.NewDecoder(conn).Decode(&response); err != nil {
+
+				response, err := readResponse(tc.conn, codec)
+				if err != nil {
 					log.Print(err)
+					tc.conn.Close()
 					return
 				}
+				pool.put(tc)
+
+				var cpuAvg, cpuMax float64
+				if len(response.CPUPercent) > 0 {
+					cpuAvg = floats.Sum(response.CPUPercent) / float64(len(response.CPUPercent))
+					cpuMax = floats.Max(response.CPUPercent)
+				}
 
 				results <- requestResult{
 					Request:       r,
@@ -138,6 +231,12 @@ func Bench(ctx context.Context, c BenchConfig) (BenchResult, error) {
 					Memory:        response.Memory,
 					JobNumber:     response.Jobs,
 					CPU:           response.CPU,
+					QueueDepth:    response.QueueDepth,
+					Overloaded:    response.Error != "",
+					Load1:         response.Load1,
+					MemPercent:    response.MemPercent,
+					CPUPercentAvg: cpuAvg,
+					CPUPercentMax: cpuMax,
 				}
 
 			}()
@@ -148,94 +247,111 @@ func Bench(ctx context.Context, c BenchConfig) (BenchResult, error) {
 		return nil
 	})
 
+	var counters progressCounters
+	reportDone := make(chan struct{})
+	benchStart := time.Now()
+	if c.Reporter != nil && c.ReportInterval > 0 {
+		g.Go(func() error {
+			ticker := time.NewTicker(c.ReportInterval)
+			defer ticker.Stop()
+			sample := progressSample{at: benchStart}
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-reportDone:
+					return nil
+				case now := <-ticker.C:
+					sample = reportTick(c.Reporter, &counters, benchStart, sample, now, c.TotRequests)
+				}
+			}
+		})
+	}
+
 	toReturn := make(chan BenchResult)
 	defer close(toReturn)
 	g.Go(func() error {
-
-		var slowRt []float64 = make([]float64, int(nSlowRequest))
-		var slowWt []float64 = make([]float64, int(nSlowRequest))
-		var slowRtt []float64 = make([]float64, int(nSlowRequest))
-		var fastRt []float64 = make([]float64, nFastRequest)
-		var fastWt []float64 = make([]float64, nFastRequest)
-		var fastRtt []float64 = make([]float64, nFastRequest)
-		var memory []float64 = make([]float64, c.TotRequests)
-		var jobs []float64 = make([]float64, c.TotRequests)
-		var cpu []float64 = make([]float64, c.TotRequests)
+		defer close(reportDone)
+
+		var slowRt []float64 = make([]float64, 0, int(nSlowRequest))
+		var slowWt []float64 = make([]float64, 0, int(nSlowRequest))
+		var slowRtt []float64 = make([]float64, 0, int(nSlowRequest))
+		var fastRt []float64 = make([]float64, 0, nFastRequest)
+		var fastWt []float64 = make([]float64, 0, nFastRequest)
+		var fastRtt []float64 = make([]float64, 0, nFastRequest)
+		var memory []float64 = make([]float64, 0, c.TotRequests)
+		var jobs []float64 = make([]float64, 0, c.TotRequests)
+		var cpu []float64 = make([]float64, 0, c.TotRequests)
+		var slowDepth []float64 = make([]float64, 0, int(nSlowRequest))
+		var fastDepth []float64 = make([]float64, 0, nFastRequest)
+		var load1 []float64 = make([]float64, 0, c.TotRequests)
+		var memPercent []float64 = make([]float64, 0, c.TotRequests)
+		var cpuPercentAvg []float64 = make([]float64, 0, c.TotRequests)
+		var cpuPercentMax []float64 = make([]float64, 0, c.TotRequests)
 
 		start := time.Now()
 		n := 0
-		idxSlow := 0
-		idxFast := 0
+		slowDrops := 0
+		fastDrops := 0
 		for result := range results {
+			counters.observe(result)
 			switch result.Request {
 			case SlowRequest:
-				slowRt[idxSlow] = float64(result.ResidenceTime)
-				slowWt[idxSlow] = float64(result.WaitingTime)
-				slowRtt[idxSlow] = float64(result.RoundTripTime)
-				idxSlow += 1
+				slowDepth = append(slowDepth, float64(result.QueueDepth))
+				if result.Overloaded {
+					slowDrops += 1
+					continue
+				}
+				slowRt = append(slowRt, float64(result.ResidenceTime))
+				slowWt = append(slowWt, float64(result.WaitingTime))
+				slowRtt = append(slowRtt, float64(result.RoundTripTime))
 			case FastRequest:
-				fastRt[idxFast] = float64(result.ResidenceTime)
-				fastWt[idxFast] = float64(result.WaitingTime)
-				fastRtt[idxFast] = float64(result.RoundTripTime)
-				idxFast += 1
+				fastDepth = append(fastDepth, float64(result.QueueDepth))
+				if result.Overloaded {
+					fastDrops += 1
+					continue
+				}
+				fastRt = append(fastRt, float64(result.ResidenceTime))
+				fastWt = append(fastWt, float64(result.WaitingTime))
+				fastRtt = append(fastRtt, float64(result.RoundTripTime))
 			default:
 				log.Printf("unknown request type: %d", result.Request)
+				continue
+			}
+			if samples != nil {
+				fmt.Fprintf(samples, "%d;%d;%d;%d\n", result.Request, result.ResidenceTime, result.WaitingTime, result.RoundTripTime)
 			}
-			memory[n] = float64(result.Memory)
-			jobs[n] = float64(result.JobNumber)
-			cpu[n] = float64(result.CPU)
+			memory = append(memory, float64(result.Memory))
+			jobs = append(jobs, float64(result.JobNumber))
+			cpu = append(cpu, float64(result.CPU))
+			load1 = append(load1, result.Load1)
+			memPercent = append(memPercent, result.MemPercent)
+			cpuPercentAvg = append(cpuPercentAvg, result.CPUPercentAvg)
+			cpuPercentMax = append(cpuPercentMax, result.CPUPercentMax)
 			n += 1
 		}
 		elapsed := time.Since(start)
 		rps := float64(n) / elapsed.Seconds()
 
 		toReturn <- BenchResult{
-			Rps: rps,
-			SlowRt: benchResult{
-				Average: stat.Mean(slowRt, nil),
-				Min:     floats.Min(slowRt),
-				Max:     floats.Max(slowRt),
-			},
-			SlowWt: benchResult{
-				Average: stat.Mean(slowWt, nil),
-				Min:     floats.Min(slowWt),
-				Max:     floats.Max(slowWt),
-			},
-			SlowRtt: benchResult{
-				Average: stat.Mean(slowRtt, nil),
-				Min:     floats.Min(slowRtt),
-				Max:     floats.Max(slowRtt),
-			},
-			FastRt: benchResult{
-				Average: stat.Mean(fastRt, nil),
-				Min:     floats.Min(fastRt),
-				Max:     floats.Max(fastRt),
-			},
-			FastWt: benchResult{
-				Average: stat.Mean(fastWt, nil),
-				Min:     floats.Min(fastWt),
-				Max:     floats.Max(fastWt),
-			},
-			FastRtt: benchResult{
-				Average: stat.Mean(fastRtt, nil),
-				Min:     floats.Min(fastRtt),
-				Max:     floats.Max(fastRtt),
-			},
-			Memory: benchResult{
-				Average: stat.Mean(memory, nil),
-				Min:     floats.Min(memory),
-				Max:     floats.Max(memory),
-			},
-			Jobs: benchResult{
-				Average: stat.Mean(jobs, nil),
-				Min:     floats.Min(jobs),
-				Max:     floats.Max(jobs),
-			},
-			CPU: benchResult{
-				Average: stat.Mean(cpu, nil),
-				Min:     floats.Min(cpu),
-				Max:     floats.Max(cpu),
-			},
+			Rps:            rps,
+			SlowRt:         newBenchResult(slowRt),
+			SlowWt:         newBenchResult(slowWt),
+			SlowRtt:        newBenchResult(slowRtt),
+			FastRt:         newBenchResult(fastRt),
+			FastWt:         newBenchResult(fastWt),
+			FastRtt:        newBenchResult(fastRtt),
+			Memory:         newBenchResult(memory),
+			Jobs:           newBenchResult(jobs),
+			CPU:            newBenchResult(cpu),
+			SlowQueueDepth: newBenchResult(slowDepth),
+			FastQueueDepth: newBenchResult(fastDepth),
+			SlowDrops:      slowDrops,
+			FastDrops:      fastDrops,
+			Load1:          newBenchResult(load1),
+			MemPercent:     newBenchResult(memPercent),
+			CPUPercentAvg:  newBenchResult(cpuPercentAvg),
+			CPUPercentMax:  newBenchResult(cpuPercentMax),
 		}
 
 		return nil
@@ -244,7 +360,6 @@ func Bench(ctx context.Context, c BenchConfig) (BenchResult, error) {
 	g.Go(func() error {
 
 		defer close(requests)
-		defer close(terminationSignal)
 		for i := 0; i < 2; i++ {
 			<-doneSendingJobs
 		}
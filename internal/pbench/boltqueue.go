@@ -0,0 +1,249 @@
+package pbench
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"net"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltPollInterval bounds how long Dequeue waits between cursor scans when
+// the queue bucket is empty, since bbolt has no blocking-pop primitive.
+const boltPollInterval = 50 * time.Millisecond
+
+// inflightEntry remembers, in memory only, what Dequeue handed out for a
+// given Job.ID: the bolt key so Ack can delete it without a bucket scan.
+type inflightEntry struct {
+	key []byte
+}
+
+// BoltJobQueue is a JobQueue backed by a BoltDB bucket, so an admitted
+// backlog survives a server restart. Jobs are moved from the queue bucket
+// to an inflight bucket on Dequeue and deleted from it on Ack; anything
+// still in the inflight bucket when NewBoltJobQueue runs was dequeued but
+// never acked by a previous process, so it is requeued.
+type BoltJobQueue struct {
+	db       *bolt.DB
+	bucket   []byte
+	inflight []byte
+
+	mu        sync.Mutex
+	inflights map[string]inflightEntry
+	// conns holds the live connection for a job ID from Enqueue until
+	// Dequeue hands it out, exactly as RedisJobQueue.conns does; the
+	// connection never survives a restart, only the job itself does.
+	conns map[string]net.Conn
+
+	notify chan struct{}
+}
+
+// NewBoltJobQueue opens (creating if necessary) the BoltDB database at
+// path and prepares the bucket pair for priority class name.
+func NewBoltJobQueue(path, name string) (*BoltJobQueue, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := []byte(name + "-queue")
+	inflight := []byte(name + "-inflight")
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(inflight)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	q := &BoltJobQueue{
+		db:        db,
+		bucket:    bucket,
+		inflight:  inflight,
+		inflights: make(map[string]inflightEntry),
+		conns:     make(map[string]net.Conn),
+		notify:    make(chan struct{}, 1),
+	}
+
+	if err := q.requeueInflight(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// requeueInflight moves every job left in the inflight bucket by a
+// previous, crashed process back into the queue bucket.
+func (q *BoltJobQueue) requeueInflight() error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		inflight := tx.Bucket(q.inflight)
+		queue := tx.Bucket(q.bucket)
+
+		var keys [][]byte
+		c := inflight.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if err := queue.Put(k, v); err != nil {
+				return err
+			}
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := inflight.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (q *BoltJobQueue) Enqueue(j Job) error {
+	data, err := encodePersistedJob(j)
+	if err != nil {
+		return err
+	}
+	// Register the connection before the job becomes visible to Dequeue,
+	// so a concurrent Dequeue never hands it out without one.
+	if j.Client != nil {
+		q.mu.Lock()
+		q.conns[j.ID] = j.Client
+		q.mu.Unlock()
+	}
+	if err := q.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(q.bucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(seq), data)
+	}); err != nil {
+		q.mu.Lock()
+		delete(q.conns, j.ID)
+		q.mu.Unlock()
+		return err
+	}
+	q.signal()
+	return nil
+}
+
+func (q *BoltJobQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *BoltJobQueue) Dequeue(ctx context.Context) (Job, error) {
+	for {
+		job, found, err := q.dequeueOnce()
+		if err != nil {
+			return Job{}, err
+		}
+		if found {
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		case <-q.notify:
+		case <-time.After(boltPollInterval):
+		}
+	}
+}
+
+func (q *BoltJobQueue) dequeueOnce() (Job, bool, error) {
+	var (
+		job   Job
+		key   []byte
+		found bool
+	)
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		queue := tx.Bucket(q.bucket)
+		c := queue.Cursor()
+		k, v := c.First()
+		if k == nil {
+			return nil
+		}
+		p, err := decodePersistedJob(v)
+		if err != nil {
+			return err
+		}
+		if err := queue.Delete(k); err != nil {
+			return err
+		}
+		if err := tx.Bucket(q.inflight).Put(k, v); err != nil {
+			return err
+		}
+		key = append([]byte(nil), k...)
+		job = Job{ID: p.ID, Request: p.Request, Response: p.Response}
+		found = true
+		return nil
+	})
+	if err != nil || !found {
+		return Job{}, false, err
+	}
+
+	q.mu.Lock()
+	job.Client = q.conns[job.ID]
+	delete(q.conns, job.ID)
+	q.inflights[job.ID] = inflightEntry{key: key}
+	q.mu.Unlock()
+
+	return job, true, nil
+}
+
+func (q *BoltJobQueue) Ack(id string) error {
+	q.mu.Lock()
+	entry, ok := q.inflights[id]
+	delete(q.inflights, id)
+	q.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(q.inflight).Delete(entry.key)
+	})
+}
+
+func (q *BoltJobQueue) Len() int {
+	n := 0
+	q.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(q.bucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Close releases the underlying BoltDB file.
+func (q *BoltJobQueue) Close() error {
+	return q.db.Close()
+}
+
+func encodePersistedJob(j Job) ([]byte, error) {
+	var buf bytes.Buffer
+	p := persistedJob{ID: j.ID, Request: j.Request, Response: j.Response}
+	if err := gob.NewEncoder(&buf).Encode(p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodePersistedJob(data []byte) (persistedJob, error) {
+	var p persistedJob
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&p)
+	return p, err
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -5,13 +5,25 @@ import (
 	"time"
 )
 
+// DefaultBufferSize is the Buffer capacity cmd/server falls back to when
+// -buffer-size isn't set, and the offset range pbench.Bench draws
+// simulated requests from when it has no other way to learn the server's
+// actual buffer size.
+const DefaultBufferSize = 1024
+
+// Buffer simulates the backing store a real job would read or write:
+// Slow sleeps for slowTime (standing in for a slow storage write) before
+// writing v at pos, Fast reads the value back at pos with no simulated
+// latency.
 type Buffer struct {
-	data []int
+	data     []int
+	slowTime time.Duration
 }
 
-func NewBuffer(size int) *Buffer {
+func NewBuffer(size int, slowTime time.Duration) *Buffer {
 	return &Buffer{
-		data: make([]int, size),
+		data:     make([]int, size),
+		slowTime: slowTime,
 	}
 }
 
@@ -21,7 +33,7 @@ var (
 
 func (b *Buffer) Slow(v, pos int) error {
 
-	time.Sleep(time.Millisecond)
+	time.Sleep(b.slowTime)
 
 	if pos >= len(b.data) {
 		return ErrOutOfRange
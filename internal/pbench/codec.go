@@ -0,0 +1,47 @@
+package pbench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Codec encodes and decodes the values exchanged between client and server
+// on a framed connection, so the wire format can be swapped without
+// touching Server or Bench.
+type Codec interface {
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+// NewCodec resolves a Codec by name. An empty name defaults to "json".
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "", "json":
+		return jsonCodec{}, nil
+	case "proto":
+		return protoCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported codec: %q", name)
+	}
+}
+
+// jsonCodec is the original wire format: a length-prefixed frame carrying a
+// JSON-encoded payload.
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(w io.Writer, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFrame(w, payload)
+}
+
+func (jsonCodec) Decode(r io.Reader, v any) error {
+	payload, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
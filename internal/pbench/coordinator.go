@@ -0,0 +1,311 @@
+package pbench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// BackendPolicy selects which backend a Coordinator forwards a request to.
+type BackendPolicy int
+
+const (
+	RoundRobin BackendPolicy = iota
+	LeastOutstanding
+	ConsistentHash
+)
+
+func ParseBackendPolicy(s string) (BackendPolicy, error) {
+	switch strings.ToLower(s) {
+	case "round-robin", "":
+		return RoundRobin, nil
+	case "least-outstanding":
+		return LeastOutstanding, nil
+	case "consistent-hash":
+		return ConsistentHash, nil
+	default:
+		return 0, fmt.Errorf("unknown backend policy: %q", s)
+	}
+}
+
+// maxBackendFailures is how many consecutive failures, of forwarded
+// requests and health-check probes alike, take a backend out of rotation.
+const maxBackendFailures = 3
+
+// backend wraps a connection pool to a single pbench.Server instance along
+// with the bookkeeping the Coordinator needs to route around failures.
+type backend struct {
+	address     string
+	pool        *tcpConnPool
+	outstanding int64
+	healthy     int32
+	failures    int32
+}
+
+func (b *backend) isHealthy() bool { return atomic.LoadInt32(&b.healthy) == 1 }
+
+func (b *backend) markUnhealthy() {
+	if atomic.AddInt32(&b.failures, 1) >= maxBackendFailures {
+		atomic.StoreInt32(&b.healthy, 0)
+	}
+}
+
+func (b *backend) markHealthy() {
+	atomic.StoreInt32(&b.failures, 0)
+	atomic.StoreInt32(&b.healthy, 1)
+}
+
+// Coordinator accepts client connections on a single address and shards
+// each request across a fixed set of backend pbench.Server instances. It
+// keeps one tcpConnPool per backend, reusing the pool implementation the
+// benchmarker already uses to talk to a single server.
+type Coordinator struct {
+	backends []*backend
+	policy   BackendPolicy
+	codec    Codec
+	rr       uint64
+	// ring is a sorted hash ring built once over the full backend set, so
+	// ConsistentHash's mapping only shifts for the fraction of keys owned
+	// by a backend that actually flips health, instead of reshuffling the
+	// whole keyspace whenever healthyBackends' length changes.
+	ring []hashRingEntry
+}
+
+// NewCoordinator builds one connection pool per backend address; it does
+// not dial anything until the first request is forwarded.
+func NewCoordinator(addresses []string, policy BackendPolicy, codec Codec, maxIdleConns, maxOpenConn int) (*Coordinator, error) {
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("pbench: coordinator needs at least one backend")
+	}
+
+	backends := make([]*backend, 0, len(addresses))
+	for _, addr := range addresses {
+		pool, err := createTcpConnPool(&tcpConfig{
+			Address:      addr,
+			MaxIdleConns: maxIdleConns,
+			MaxOpenConn:  maxOpenConn,
+		})
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, &backend{address: addr, pool: pool, healthy: 1})
+	}
+
+	return &Coordinator{backends: backends, policy: policy, codec: codec, ring: buildHashRing(backends)}, nil
+}
+
+// hashRingEntry is one backend's position on the consistent-hash ring.
+type hashRingEntry struct {
+	hash    uint32
+	backend *backend
+}
+
+// buildHashRing hashes each backend's address once and sorts the result,
+// giving a ring that is stable across health flips: only the keys owned by
+// the backend that actually changed health need to move.
+func buildHashRing(backends []*backend) []hashRingEntry {
+	ring := make([]hashRingEntry, len(backends))
+	for i, b := range backends {
+		h := fnv.New32a()
+		fmt.Fprint(h, b.address)
+		ring[i] = hashRingEntry{hash: h.Sum32(), backend: b}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	return ring
+}
+
+// consistentHashBackend walks the ring clockwise from offset's hash to the
+// first healthy backend, so the same offset always lands on the same
+// backend unless that backend is down.
+func (c *Coordinator) consistentHashBackend(offset int) *backend {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d", offset)
+	target := h.Sum32()
+
+	start := sort.Search(len(c.ring), func(i int) bool { return c.ring[i].hash >= target })
+	for i := 0; i < len(c.ring); i++ {
+		entry := c.ring[(start+i)%len(c.ring)]
+		if entry.backend.isHealthy() {
+			return entry.backend
+		}
+	}
+	return c.ring[start%len(c.ring)].backend
+}
+
+// Start accepts client connections on addr and, for each one, decodes
+// framed requests and forwards them to a backend chosen by c.policy. It
+// also runs one health-check goroutine per backend for as long as ctx is
+// alive.
+func (c *Coordinator) Start(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return err
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+
+	g.Go(func() error {
+		<-ctx.Done()
+		listener.Close()
+		return nil
+	})
+
+	for _, b := range c.backends {
+		b := b
+		g.Go(func() error {
+			c.healthCheck(ctx, b)
+			return nil
+		})
+	}
+
+	for {
+		client, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			break
+		} else if err != nil {
+			log.Print(err)
+			continue
+		}
+
+		g.Go(func() error {
+			if err := c.handleConnection(client); parseErr(err) != nil {
+				log.Print(err)
+			}
+			return nil
+		})
+	}
+
+	for _, b := range c.backends {
+		b.pool.close()
+	}
+
+	return g.Wait()
+}
+
+// handleConnection forwards every framed request read from conn to a
+// backend and streams the backend's response back, for as long as the
+// client keeps the connection open.
+func (c *Coordinator) handleConnection(conn net.Conn) error {
+	defer conn.Close()
+
+	for {
+		var req wireRequest
+		if err := c.codec.Decode(conn, &req); err != nil {
+			return err
+		}
+
+		b := c.pickBackend(req)
+
+		resp, err := c.forward(b, req)
+		if err != nil {
+			b.markUnhealthy()
+			resp = Response{Error: err.Error()}
+		} else {
+			b.markHealthy()
+		}
+
+		if err := WriteResponse(conn, c.codec, resp); err != nil {
+			return err
+		}
+	}
+}
+
+// forward round-trips req through b's connection pool, tracking b's
+// outstanding request count for the least-outstanding policy.
+func (c *Coordinator) forward(b *backend, req wireRequest) (Response, error) {
+	atomic.AddInt64(&b.outstanding, 1)
+	defer atomic.AddInt64(&b.outstanding, -1)
+
+	tc, err := b.pool.get()
+	if err != nil {
+		return Response{}, err
+	}
+
+	if err := writeRequest(tc.conn, c.codec, req); err != nil {
+		tc.conn.Close()
+		return Response{}, err
+	}
+
+	resp, err := readResponse(tc.conn, c.codec)
+	if err != nil {
+		tc.conn.Close()
+		return Response{}, err
+	}
+
+	b.pool.put(tc)
+	return resp, nil
+}
+
+// pickBackend applies c.policy to select a backend for req. RoundRobin and
+// LeastOutstanding operate over the currently healthy backends, falling
+// back to the full set if every backend is unhealthy rather than refusing
+// the request outright; ConsistentHash instead walks the fixed hash ring,
+// since picking among only the healthy backends there would reshuffle the
+// whole keyspace on every health flip.
+func (c *Coordinator) pickBackend(req wireRequest) *backend {
+	if c.policy == ConsistentHash {
+		return c.consistentHashBackend(req.Offset)
+	}
+
+	candidates := c.healthyBackends()
+	if len(candidates) == 0 {
+		candidates = c.backends
+	}
+
+	switch c.policy {
+	case LeastOutstanding:
+		best := candidates[0]
+		for _, b := range candidates[1:] {
+			if atomic.LoadInt64(&b.outstanding) < atomic.LoadInt64(&best.outstanding) {
+				best = b
+			}
+		}
+		return best
+	default:
+		idx := atomic.AddUint64(&c.rr, 1) % uint64(len(candidates))
+		return candidates[idx]
+	}
+}
+
+func (c *Coordinator) healthyBackends() []*backend {
+	healthy := make([]*backend, 0, len(c.backends))
+	for _, b := range c.backends {
+		if b.isHealthy() {
+			healthy = append(healthy, b)
+		}
+	}
+	return healthy
+}
+
+// healthCheck periodically probes b until ctx is cancelled, re-admitting
+// it to the rotation once a dial succeeds. Healthy backends are probed
+// too, since the probe is cheap and this avoids a gap where b stays
+// marked healthy forever if the ticker caught it mid-outage.
+func (c *Coordinator) healthCheck(ctx context.Context, b *backend) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			conn, err := net.DialTimeout("tcp", b.address, time.Second)
+			if err != nil {
+				b.markUnhealthy()
+				continue
+			}
+			conn.Close()
+			b.markHealthy()
+		}
+	}
+}
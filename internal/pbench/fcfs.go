@@ -6,24 +6,69 @@ import (
 
 type FCFS struct {
 	out chan<- Job
-	in  <-chan Job
+	in  JobQueue
+	*runner
 }
 
-func NewFCFS(in <-chan Job, out chan<- Job) *FCFS {
+func NewFCFS(in JobQueue, out chan<- Job) *FCFS {
 	return &FCFS{
-		out: out,
-		in:  in,
+		out:    out,
+		in:     in,
+		runner: newRunner(),
 	}
 }
 
+// Start launches the FCFS goroutine, which forwards every job dequeued
+// from in to out in arrival order, and returns immediately; call Wait to
+// block for its terminal error. The goroutine exits once ctx is cancelled,
+// or once Stop is called and in's current backlog has been drained.
 func (f *FCFS) Start(ctx context.Context) error {
-	defer close(f.out)
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case r := <-f.in:
-			f.out <- r
+	f.runner.start(ctx, func(ctx context.Context, markReady func(), draining <-chan struct{}) error {
+		markReady()
+		defer close(f.out)
+		// waitCtx also ends once Stop is called, so a Dequeue waiting on
+		// an empty queue returns then.
+		waitCtx, cancelWait := context.WithCancel(ctx)
+		defer cancelWait()
+		go func() {
+			select {
+			case <-draining:
+			case <-waitCtx.Done():
+			}
+			cancelWait()
+		}()
+		for {
+			select {
+			case <-draining:
+				for f.in.Len() > 0 {
+					job, err := f.in.Dequeue(ctx)
+					if err != nil {
+						return nil
+					}
+					f.out <- job
+					f.in.Ack(job.ID)
+				}
+				return nil
+			default:
+			}
+
+			job, err := f.in.Dequeue(waitCtx)
+			if err != nil {
+				if ctx.Err() == nil && waitCtx.Err() != nil {
+					// Stop was called while waiting; drain above.
+					continue
+				}
+				return nil
+			}
+			f.out <- job
+			f.in.Ack(job.ID)
 		}
-	}
+	})
+	return nil
+}
+
+// Stop cancels the FCFS goroutine and waits for it to exit, up to ctx's
+// deadline.
+func (f *FCFS) Stop(ctx context.Context) error {
+	return f.runner.stop(ctx)
 }
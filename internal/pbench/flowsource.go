@@ -0,0 +1,66 @@
+package pbench
+
+import (
+	"context"
+	"reflect"
+)
+
+// flowSource turns a JobQueue's blocking Dequeue into a channel fed by a
+// single dedicated goroutine, so a scheduler can wait on several flows at
+// once with waitAny instead of wrapping every Dequeue call in its own
+// context.WithTimeout and polling: the queue's own Dequeue already blocks
+// however its backend does (a channel select for PriorityQueue, a notify
+// channel for BoltJobQueue, BRPopLPush for RedisJobQueue), so flowSource
+// never needs to poll either.
+type flowSource struct {
+	jobs chan Job
+}
+
+// newFlowSource starts the feeding goroutine, which exits once ctx is
+// done or q.Dequeue returns an error.
+func newFlowSource(ctx context.Context, q JobQueue) *flowSource {
+	fs := &flowSource{jobs: make(chan Job)}
+	go func() {
+		for {
+			job, err := q.Dequeue(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case fs.jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return fs
+}
+
+// tryRecv returns the next buffered job without blocking, so a caller can
+// tell "this flow has nothing queued right now" apart from "this flow is
+// merely slow to answer".
+func (fs *flowSource) tryRecv() (Job, bool) {
+	select {
+	case job := <-fs.jobs:
+		return job, true
+	default:
+		return Job{}, false
+	}
+}
+
+// waitAny blocks until the first of sources has a job ready or ctx is
+// done, returning the index of the source that produced it. It uses
+// reflect.Select since the number of flows is only known at runtime.
+func waitAny(ctx context.Context, sources []*flowSource) (int, Job, bool) {
+	cases := make([]reflect.SelectCase, len(sources)+1)
+	for i, s := range sources {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(s.jobs)}
+	}
+	cases[len(sources)] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+
+	chosen, recv, ok := reflect.Select(cases)
+	if chosen == len(sources) || !ok {
+		return -1, Job{}, false
+	}
+	return chosen, recv.Interface().(Job), true
+}
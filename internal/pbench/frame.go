@@ -0,0 +1,46 @@
+package pbench
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// maxFrameSize bounds how large a single frame payload can be, guarding
+// against a corrupt or malicious length prefix forcing an unbounded
+// allocation.
+const maxFrameSize = 1 << 20
+
+// ErrFrameTooLarge is returned by readFrame when the advertised payload
+// length exceeds maxFrameSize.
+var ErrFrameTooLarge = errors.New("pbench: frame too large")
+
+// writeFrame writes payload prefixed by its length encoded as a big-endian
+// uint32, so a single connection can carry several requests/responses back
+// to back without ambiguity about where one ends and the next begins.
+func writeFrame(w io.Writer, payload []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame reads a single length-prefixed frame written by writeFrame.
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
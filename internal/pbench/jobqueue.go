@@ -0,0 +1,65 @@
+package pbench
+
+import (
+	"context"
+	"fmt"
+)
+
+// JobQueue is a pluggable backing store for one priority class of admitted
+// jobs, sitting where a bare channel used to between the accept loop and
+// a scheduler. Enqueue applies admission control; Dequeue is polled by a
+// scheduler up to its quantum, blocking until a job is available or ctx is
+// done; Ack commits that a dequeued job was actually handed off, so a
+// durable backend can stop tracking it for crash recovery.
+//
+// Job.Client is a live net.Conn, which only ever makes sense inside the
+// process that accepted it: BoltJobQueue and RedisJobQueue can persist
+// everything else about a Job and recover it after a restart, but they
+// cannot hand a dequeued Job its original connection back once the
+// process that accepted it is gone. A Job dequeued with a nil Client is
+// unreachable and should be dropped rather than written a response to.
+type JobQueue interface {
+	Enqueue(j Job) error
+	Dequeue(ctx context.Context) (Job, error)
+	Ack(id string) error
+	Len() int
+}
+
+// JobQueueConfig carries the settings a durable JobQueue backend needs;
+// fields that don't apply to the selected backend are ignored.
+type JobQueueConfig struct {
+	// Capacity and Policy configure the in-memory backend only.
+	Capacity int
+	Policy   OverflowPolicy
+	// BoltPath is the database file a "bolt" backend opens. bbolt locks
+	// the file exclusively while it is open, so each priority class needs
+	// a BoltPath of its own; its buckets are still named after the class.
+	BoltPath string
+	// RedisAddress is the "host:port" a "redis" backend dials.
+	RedisAddress string
+}
+
+// NewJobQueue builds the JobQueue for one priority class, named name (e.g.
+// "high" or "low") so redis keys for different priority classes sharing
+// the same RedisAddress don't collide.
+func NewJobQueue(backend, name string, cfg JobQueueConfig) (JobQueue, error) {
+	switch backend {
+	case "", "memory":
+		return NewPriorityQueue(cfg.Capacity, cfg.Policy), nil
+	case "bolt":
+		return NewBoltJobQueue(cfg.BoltPath, name)
+	case "redis":
+		return NewRedisJobQueue(cfg.RedisAddress, name)
+	default:
+		return nil, fmt.Errorf("unknown queue backend: %q", backend)
+	}
+}
+
+// persistedJob is the subset of Job a durable backend actually stores:
+// Job.Client is never serialized, for the reason given in JobQueue's doc
+// comment.
+type persistedJob struct {
+	ID       string
+	Request  Request
+	Response Response
+}
@@ -1,29 +1,58 @@
-package pbench
-
-import (
-	"net"
-	"time"
-)
-
-type Request uint32
-
-const (
-	SlowRequest Request = iota
-	FastRequest
-)
-
-type Response struct {
-	AcceptedTs time.Time `json:"accepted_ts"`
-	RunningTs  time.Time `json:"running_ts"`
-	FinishedTs time.Time `json:"finished_ts"`
-	Memory     uint64    `json:"memory"`
-	Jobs       int       `json:"jobs_number"`
-	CPU        float64   `json:"cpu"`
-	Alg        string    `json:"string"`
-}
-
-type Job struct {
-	Request  Request
-	Response Response
-	Client   net.Conn
-}
+package pbench
+
+import (
+	"net"
+	"time"
+)
+
+type Request uint32
+
+const (
+	SlowRequest Request = iota
+	FastRequest
+)
+
+type Response struct {
+	AcceptedTs time.Time `json:"accepted_ts"`
+	RunningTs  time.Time `json:"running_ts"`
+	FinishedTs time.Time `json:"finished_ts"`
+	Memory     uint64    `json:"memory"`
+	Jobs       int       `json:"jobs_number"`
+	CPU        float64   `json:"cpu"`
+	Alg        string    `json:"string"`
+	// QueueDepth is the length of the priority queue the request landed on
+	// right before admission, as reported by Server.Stats.
+	QueueDepth int `json:"queue_depth,omitempty"`
+	// Error is set instead of the fields above when the request was turned
+	// away by admission control; see ErrOverloaded.
+	Error string `json:"error,omitempty"`
+
+	// The fields below are a sysstats.Snapshot taken at admission time,
+	// flattened rather than nested to keep every Codec implementation
+	// (including protoCodec's hand-written wire format) working on plain
+	// scalars and a repeated field.
+	Uptime       uint64    `json:"uptime,omitempty"`
+	Load1        float64   `json:"load1,omitempty"`
+	Load5        float64   `json:"load5,omitempty"`
+	Load15       float64   `json:"load15,omitempty"`
+	CPUPercent   []float64 `json:"cpu_percent,omitempty"`
+	MemUsed      uint64    `json:"mem_used,omitempty"`
+	MemTotal     uint64    `json:"mem_total,omitempty"`
+	MemPercent   float64   `json:"mem_percent,omitempty"`
+	NetBytesSent uint64    `json:"net_bytes_sent,omitempty"`
+	NetBytesRecv uint64    `json:"net_bytes_recv,omitempty"`
+}
+
+type Job struct {
+	// ID identifies a Job across Enqueue/Dequeue/Ack on a JobQueue. It is
+	// assigned by the server that accepts the request, not by the queue
+	// itself.
+	ID string
+	// Payload and Offset are the value and Buffer position the request
+	// operates on; Fast requests ignore Payload.
+	Payload  int
+	Offset   int
+	Request  Request
+	Response Response
+	Client   net.Conn
+}
@@ -0,0 +1,122 @@
+package pbench
+
+import (
+	"context"
+	"sort"
+)
+
+type pqFlow struct {
+	queue JobQueue
+	prio  int
+}
+
+// PQ is a strict priority scheduler: on every round it tries flows in
+// descending prio order and fully drains a flow's current backlog before
+// moving to the next one, so a higher-priority flow with jobs to send
+// always preempts a lower-priority one -- unlike DRR's round-robin
+// quantum or WFQ's virtual-time interleaving, a busy high-priority flow
+// can starve a lower one entirely.
+type PQ struct {
+	flows   []pqFlow
+	outChan chan Job
+	*runner
+}
+
+func NewPQ(outChan chan Job) *PQ {
+	return &PQ{
+		outChan: outChan,
+		runner:  newRunner(),
+	}
+}
+
+// Input registers in as the flow for priority prio; higher values are
+// drained first.
+func (p *PQ) Input(prio int, in JobQueue) error {
+	if prio <= 0 {
+		return ErrInvalidPriorityValue
+	}
+	p.flows = append(p.flows, pqFlow{queue: in, prio: prio})
+	sort.SliceStable(p.flows, func(i, j int) bool { return p.flows[i].prio > p.flows[j].prio })
+	return nil
+}
+
+// Start launches the PQ goroutine, which forwards jobs dequeued from the
+// queues registered through Input to the output channel in strict
+// priority order, and returns immediately; call Wait to block for its
+// terminal error.
+//
+// The PQ goroutine exits when ctx is cancelled, closing the output
+// channel upon termination. Once Stop is called, it keeps draining flows
+// that still have a backlog but stops waiting on brand-new arrivals.
+func (p *PQ) Start(ctx context.Context) error {
+	p.runner.start(ctx, func(ctx context.Context, markReady func(), draining <-chan struct{}) error {
+		markReady()
+		defer close(p.outChan)
+
+		if len(p.flows) == 0 {
+			<-ctx.Done()
+			return nil
+		}
+
+		sources := make([]*flowSource, len(p.flows))
+		for i, fl := range p.flows {
+			sources[i] = newFlowSource(ctx, fl.queue)
+		}
+
+		// waitCtx also ends once Stop is called, so a wait for new
+		// arrivals that is already in progress stops then.
+		waitCtx, cancelWait := context.WithCancel(ctx)
+		defer cancelWait()
+		go func() {
+			select {
+			case <-draining:
+			case <-waitCtx.Done():
+			}
+			cancelWait()
+		}()
+
+		for {
+			dispatched := false
+			for i, fl := range p.flows {
+				for {
+					job, ok := sources[i].tryRecv()
+					if !ok {
+						// This flow is empty right now; move to the next
+						// one down the priority order.
+						break
+					}
+					p.outChan <- job
+					fl.queue.Ack(job.ID)
+					dispatched = true
+				}
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			if !dispatched {
+				select {
+				case <-draining:
+					// Every flow drained and nothing new is coming; stop
+					// instead of waiting on arrivals that will never come.
+					return nil
+				default:
+				}
+				// Every flow was empty this round; block until one of
+				// them actually has something instead of spinning.
+				i, job, ok := waitAny(waitCtx, sources)
+				if !ok {
+					return nil
+				}
+				p.outChan <- job
+				p.flows[i].queue.Ack(job.ID)
+			}
+		}
+	})
+	return nil
+}
+
+// Stop cancels the PQ goroutine and waits for it to exit, up to ctx's
+// deadline.
+func (p *PQ) Stop(ctx context.Context) error {
+	return p.runner.stop(ctx)
+}
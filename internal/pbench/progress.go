@@ -0,0 +1,185 @@
+package pbench
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Reporter receives a ProgressSnapshot on every BenchConfig.ReportInterval
+// tick while a benchmark is running. Report must return promptly: it runs
+// on the reporter's own ticking goroutine, so a slow sink only delays the
+// next sample, not the benchmark itself.
+type Reporter interface {
+	Report(ProgressSnapshot)
+}
+
+// ReporterFunc adapts a plain function to a Reporter, the same way
+// http.HandlerFunc adapts a function to a Handler.
+type ReporterFunc func(ProgressSnapshot)
+
+func (f ReporterFunc) Report(s ProgressSnapshot) { f(s) }
+
+// ProgressSnapshot describes benchmark progress as of one reporter tick.
+// The "Inst" and "SinceLastTick" fields, AvgRtt and AvgMemory cover only
+// the interval since the previous tick; the rest are cumulative since the
+// benchmark started.
+type ProgressSnapshot struct {
+	Elapsed             time.Duration `json:"elapsed"`
+	Completed           int           `json:"completed"`
+	Total               int           `json:"total"`
+	SlowCompleted       int           `json:"slow_completed"`
+	FastCompleted       int           `json:"fast_completed"`
+	SlowRps             float64       `json:"slow_rps"`
+	FastRps             float64       `json:"fast_rps"`
+	SlowRpsInst         float64       `json:"slow_rps_inst"`
+	FastRpsInst         float64       `json:"fast_rps_inst"`
+	AvgRtt              time.Duration `json:"avg_rtt"`
+	AvgMemory           uint64        `json:"avg_memory"`
+	Goroutines          int           `json:"goroutines"`
+	ErrorsSinceLastTick int           `json:"errors_since_last_tick"`
+}
+
+// NewLogReporter returns a Reporter that writes one humanized, human-
+// readable line per tick to out.
+func NewLogReporter(out io.Writer) Reporter {
+	logger := log.New(out, "", log.LstdFlags)
+	return ReporterFunc(func(s ProgressSnapshot) {
+		logger.Printf(
+			"elapsed=%s completed=%s/%s slow_rps=%.1f(inst %.1f) fast_rps=%.1f(inst %.1f) avg_rtt=%s avg_memory=%s goroutines=%d errors=%d",
+			s.Elapsed.Round(time.Second),
+			humanize.Comma(int64(s.Completed)), humanize.Comma(int64(s.Total)),
+			s.SlowRps, s.SlowRpsInst, s.FastRps, s.FastRpsInst,
+			s.AvgRtt.Round(time.Microsecond), humanize.Bytes(s.AvgMemory),
+			s.Goroutines, s.ErrorsSinceLastTick,
+		)
+	})
+}
+
+// NewJSONReporter returns a Reporter that writes one JSON object per tick
+// to out, newline-delimited. Writes are serialized, since Report may be
+// called concurrently with itself across different Bench calls sharing the
+// same Reporter.
+func NewJSONReporter(out io.Writer) Reporter {
+	enc := json.NewEncoder(out)
+	var mu sync.Mutex
+	return ReporterFunc(func(s ProgressSnapshot) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(s); err != nil {
+			log.Print(err)
+		}
+	})
+}
+
+// progressCounters are updated atomically by the results-collector
+// goroutine as each requestResult arrives, so a Reporter's ticking
+// goroutine can sample progress without contending on the results channel.
+type progressCounters struct {
+	completed     int64
+	slowCompleted int64
+	fastCompleted int64
+	errors        int64
+	rttSumNs      int64
+	rttCount      int64
+	memorySumB    int64
+	memoryCount   int64
+}
+
+func (c *progressCounters) observe(r requestResult) {
+	atomic.AddInt64(&c.completed, 1)
+	switch r.Request {
+	case SlowRequest:
+		atomic.AddInt64(&c.slowCompleted, 1)
+	case FastRequest:
+		atomic.AddInt64(&c.fastCompleted, 1)
+	}
+	if r.Overloaded {
+		atomic.AddInt64(&c.errors, 1)
+		return
+	}
+	atomic.AddInt64(&c.rttSumNs, int64(r.RoundTripTime))
+	atomic.AddInt64(&c.rttCount, 1)
+	atomic.AddInt64(&c.memorySumB, int64(r.Memory))
+	atomic.AddInt64(&c.memoryCount, 1)
+}
+
+// progressSample is the state reportTick needs from the previous tick to
+// compute deltas and instantaneous rates.
+type progressSample struct {
+	at            time.Time
+	completed     int64
+	slowCompleted int64
+	fastCompleted int64
+	errors        int64
+	rttSumNs      int64
+	rttCount      int64
+	memorySumB    int64
+	memoryCount   int64
+}
+
+// reportTick samples counters, sends one ProgressSnapshot to r, and
+// returns the sample reportTick's next call should diff against.
+func reportTick(r Reporter, counters *progressCounters, start time.Time, last progressSample, now time.Time, total int) progressSample {
+	completed := atomic.LoadInt64(&counters.completed)
+	slow := atomic.LoadInt64(&counters.slowCompleted)
+	fast := atomic.LoadInt64(&counters.fastCompleted)
+	errs := atomic.LoadInt64(&counters.errors)
+	rttSum := atomic.LoadInt64(&counters.rttSumNs)
+	rttCount := atomic.LoadInt64(&counters.rttCount)
+	memorySum := atomic.LoadInt64(&counters.memorySumB)
+	memoryCount := atomic.LoadInt64(&counters.memoryCount)
+
+	elapsed := now.Sub(start)
+	sinceLast := now.Sub(last.at).Seconds()
+
+	var slowRpsInst, fastRpsInst float64
+	if sinceLast > 0 {
+		slowRpsInst = float64(slow-last.slowCompleted) / sinceLast
+		fastRpsInst = float64(fast-last.fastCompleted) / sinceLast
+	}
+
+	var avgRtt time.Duration
+	if dc := rttCount - last.rttCount; dc > 0 {
+		avgRtt = time.Duration((rttSum - last.rttSumNs) / dc)
+	}
+
+	var avgMemory uint64
+	if dc := memoryCount - last.memoryCount; dc > 0 {
+		avgMemory = uint64((memorySum - last.memorySumB) / dc)
+	}
+
+	r.Report(ProgressSnapshot{
+		Elapsed:             elapsed,
+		Completed:           int(completed),
+		Total:               total,
+		SlowCompleted:       int(slow),
+		FastCompleted:       int(fast),
+		SlowRps:             float64(slow) / elapsed.Seconds(),
+		FastRps:             float64(fast) / elapsed.Seconds(),
+		SlowRpsInst:         slowRpsInst,
+		FastRpsInst:         fastRpsInst,
+		AvgRtt:              avgRtt,
+		AvgMemory:           avgMemory,
+		Goroutines:          runtime.NumGoroutine(),
+		ErrorsSinceLastTick: int(errs - last.errors),
+	})
+
+	return progressSample{
+		at:            now,
+		completed:     completed,
+		slowCompleted: slow,
+		fastCompleted: fast,
+		errors:        errs,
+		rttSumNs:      rttSum,
+		rttCount:      rttCount,
+		memorySumB:    memorySum,
+		memoryCount:   memoryCount,
+	}
+}
@@ -0,0 +1,328 @@
+package pbench
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// protoCodec implements Codec using a hand-written, protobuf-wire-format
+// encoding of WireRequest/Response as declared in proto/messages.proto. The
+// toolchain running these benchmarks has no protoc available, so the
+// generated-code step is done by hand here instead. Unlike `protoc --go_out`
+// output, the encoding includes zero-valued fields and writes cpu_percent
+// unpacked, one fixed64 per element; Decode reads cpu_percent only in that
+// unpacked form.
+type protoCodec struct{}
+
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+)
+
+func (protoCodec) Encode(w io.Writer, v any) error {
+	var payload []byte
+	switch m := v.(type) {
+	case wireRequest:
+		payload = marshalWireRequest(m)
+	case *wireRequest:
+		payload = marshalWireRequest(*m)
+	case Response:
+		payload = marshalResponse(m)
+	case *Response:
+		payload = marshalResponse(*m)
+	default:
+		return fmt.Errorf("pbench: protoCodec cannot encode %T", v)
+	}
+	return writeFrame(w, payload)
+}
+
+func (protoCodec) Decode(r io.Reader, v any) error {
+	payload, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	switch m := v.(type) {
+	case *wireRequest:
+		return unmarshalWireRequest(payload, m)
+	case *Response:
+		return unmarshalResponse(payload, m)
+	default:
+		return fmt.Errorf("pbench: protoCodec cannot decode into %T", v)
+	}
+}
+
+func marshalWireRequest(m wireRequest) []byte {
+	var buf []byte
+	buf = appendTag(buf, 1, protoWireVarint)
+	buf = appendVarint(buf, uint64(m.Type))
+	buf = appendTag(buf, 2, protoWireVarint)
+	buf = appendVarint(buf, uint64(m.Payload))
+	buf = appendTag(buf, 3, protoWireVarint)
+	buf = appendVarint(buf, uint64(m.Offset))
+	return buf
+}
+
+func unmarshalWireRequest(data []byte, m *wireRequest) error {
+	return walkFields(data, func(field int, wireType int, buf []byte) ([]byte, error) {
+		switch field {
+		case 1:
+			v, rest, err := consumeVarint(buf)
+			m.Type = Request(v)
+			return rest, err
+		case 2:
+			v, rest, err := consumeVarint(buf)
+			m.Payload = int(v)
+			return rest, err
+		case 3:
+			v, rest, err := consumeVarint(buf)
+			m.Offset = int(v)
+			return rest, err
+		default:
+			return skipField(wireType, buf)
+		}
+	})
+}
+
+func marshalResponse(m Response) []byte {
+	var buf []byte
+	buf = appendTag(buf, 1, protoWireVarint)
+	buf = appendVarint(buf, unixNanoOrZero(m.AcceptedTs))
+	buf = appendTag(buf, 2, protoWireVarint)
+	buf = appendVarint(buf, unixNanoOrZero(m.RunningTs))
+	buf = appendTag(buf, 3, protoWireVarint)
+	buf = appendVarint(buf, unixNanoOrZero(m.FinishedTs))
+	buf = appendTag(buf, 4, protoWireVarint)
+	buf = appendVarint(buf, m.Memory)
+	buf = appendTag(buf, 5, protoWireVarint)
+	buf = appendVarint(buf, uint64(m.Jobs))
+	buf = appendTag(buf, 6, protoWireFixed64)
+	buf = appendFixed64(buf, math.Float64bits(m.CPU))
+	buf = appendTag(buf, 7, protoWireBytes)
+	buf = appendBytes(buf, []byte(m.Alg))
+	buf = appendTag(buf, 8, protoWireVarint)
+	buf = appendVarint(buf, uint64(m.QueueDepth))
+	buf = appendTag(buf, 9, protoWireBytes)
+	buf = appendBytes(buf, []byte(m.Error))
+	buf = appendTag(buf, 10, protoWireVarint)
+	buf = appendVarint(buf, m.Uptime)
+	buf = appendTag(buf, 11, protoWireFixed64)
+	buf = appendFixed64(buf, math.Float64bits(m.Load1))
+	buf = appendTag(buf, 12, protoWireFixed64)
+	buf = appendFixed64(buf, math.Float64bits(m.Load5))
+	buf = appendTag(buf, 13, protoWireFixed64)
+	buf = appendFixed64(buf, math.Float64bits(m.Load15))
+	buf = appendTag(buf, 14, protoWireVarint)
+	buf = appendVarint(buf, m.MemUsed)
+	buf = appendTag(buf, 15, protoWireVarint)
+	buf = appendVarint(buf, m.MemTotal)
+	buf = appendTag(buf, 16, protoWireFixed64)
+	buf = appendFixed64(buf, math.Float64bits(m.MemPercent))
+	buf = appendTag(buf, 17, protoWireVarint)
+	buf = appendVarint(buf, m.NetBytesSent)
+	buf = appendTag(buf, 18, protoWireVarint)
+	buf = appendVarint(buf, m.NetBytesRecv)
+	for _, p := range m.CPUPercent {
+		buf = appendTag(buf, 19, protoWireFixed64)
+		buf = appendFixed64(buf, math.Float64bits(p))
+	}
+	return buf
+}
+
+func unmarshalResponse(data []byte, m *Response) error {
+	var (
+		acceptedTs, runningTs, finishedTs int64
+	)
+	err := walkFields(data, func(field int, wireType int, buf []byte) ([]byte, error) {
+		switch field {
+		case 1:
+			v, rest, err := consumeVarint(buf)
+			acceptedTs = int64(v)
+			return rest, err
+		case 2:
+			v, rest, err := consumeVarint(buf)
+			runningTs = int64(v)
+			return rest, err
+		case 3:
+			v, rest, err := consumeVarint(buf)
+			finishedTs = int64(v)
+			return rest, err
+		case 4:
+			v, rest, err := consumeVarint(buf)
+			m.Memory = v
+			return rest, err
+		case 5:
+			v, rest, err := consumeVarint(buf)
+			m.Jobs = int(v)
+			return rest, err
+		case 6:
+			v, rest, err := consumeFixed64(buf)
+			m.CPU = math.Float64frombits(v)
+			return rest, err
+		case 7:
+			v, rest, err := consumeBytes(buf)
+			m.Alg = string(v)
+			return rest, err
+		case 8:
+			v, rest, err := consumeVarint(buf)
+			m.QueueDepth = int(v)
+			return rest, err
+		case 9:
+			v, rest, err := consumeBytes(buf)
+			m.Error = string(v)
+			return rest, err
+		case 10:
+			v, rest, err := consumeVarint(buf)
+			m.Uptime = v
+			return rest, err
+		case 11:
+			v, rest, err := consumeFixed64(buf)
+			m.Load1 = math.Float64frombits(v)
+			return rest, err
+		case 12:
+			v, rest, err := consumeFixed64(buf)
+			m.Load5 = math.Float64frombits(v)
+			return rest, err
+		case 13:
+			v, rest, err := consumeFixed64(buf)
+			m.Load15 = math.Float64frombits(v)
+			return rest, err
+		case 14:
+			v, rest, err := consumeVarint(buf)
+			m.MemUsed = v
+			return rest, err
+		case 15:
+			v, rest, err := consumeVarint(buf)
+			m.MemTotal = v
+			return rest, err
+		case 16:
+			v, rest, err := consumeFixed64(buf)
+			m.MemPercent = math.Float64frombits(v)
+			return rest, err
+		case 17:
+			v, rest, err := consumeVarint(buf)
+			m.NetBytesSent = v
+			return rest, err
+		case 18:
+			v, rest, err := consumeVarint(buf)
+			m.NetBytesRecv = v
+			return rest, err
+		case 19:
+			v, rest, err := consumeFixed64(buf)
+			m.CPUPercent = append(m.CPUPercent, math.Float64frombits(v))
+			return rest, err
+		default:
+			return skipField(wireType, buf)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	m.AcceptedTs = timeFromUnixNano(acceptedTs)
+	m.RunningTs = timeFromUnixNano(runningTs)
+	m.FinishedTs = timeFromUnixNano(finishedTs)
+	return nil
+}
+
+func timeFromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// unixNanoOrZero is UnixNano, except a zero Time -- e.g. RunningTs/FinishedTs
+// on a Response rejected before it ran -- encodes as 0 instead of whatever
+// UnixNano() of the zero Time wraps around to, so timeFromUnixNano decodes
+// it back to a zero Time rather than some arbitrary instant.
+func unixNanoOrZero(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+func appendTag(buf []byte, field, wireType int) []byte {
+	return appendVarint(buf, uint64(field)<<3|uint64(wireType))
+}
+
+func appendVarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendFixed64(buf []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	return append(buf, tmp[:]...)
+}
+
+func appendBytes(buf []byte, v []byte) []byte {
+	buf = appendVarint(buf, uint64(len(v)))
+	return append(buf, v...)
+}
+
+// walkFields iterates over the tag/value pairs in data, handing each one to
+// fn which must return the slice remaining after it consumed its value.
+func walkFields(data []byte, fn func(field, wireType int, buf []byte) ([]byte, error)) error {
+	for len(data) > 0 {
+		tag, rest, err := consumeVarint(data)
+		if err != nil {
+			return err
+		}
+		field := int(tag >> 3)
+		wireType := int(tag & 0x7)
+		rest, err = fn(field, wireType, rest)
+		if err != nil {
+			return err
+		}
+		data = rest
+	}
+	return nil
+}
+
+func consumeVarint(buf []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return 0, nil, fmt.Errorf("pbench: invalid varint")
+	}
+	return v, buf[n:], nil
+}
+
+func consumeFixed64(buf []byte) (uint64, []byte, error) {
+	if len(buf) < 8 {
+		return 0, nil, fmt.Errorf("pbench: truncated fixed64")
+	}
+	return binary.LittleEndian.Uint64(buf[:8]), buf[8:], nil
+}
+
+func consumeBytes(buf []byte) ([]byte, []byte, error) {
+	n, rest, err := consumeVarint(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uint64(len(rest)) < n {
+		return nil, nil, fmt.Errorf("pbench: truncated length-delimited field")
+	}
+	return rest[:n], rest[n:], nil
+}
+
+func skipField(wireType int, buf []byte) ([]byte, error) {
+	switch wireType {
+	case protoWireVarint:
+		_, rest, err := consumeVarint(buf)
+		return rest, err
+	case protoWireFixed64:
+		_, rest, err := consumeFixed64(buf)
+		return rest, err
+	case protoWireBytes:
+		_, rest, err := consumeBytes(buf)
+		return rest, err
+	default:
+		return nil, fmt.Errorf("pbench: unsupported wire type %d", wireType)
+	}
+}
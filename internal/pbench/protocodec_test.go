@@ -0,0 +1,99 @@
+package pbench
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProtoCodecWireRequestRoundTrip(t *testing.T) {
+	want := wireRequest{Type: SlowRequest, Payload: 42, Offset: 7}
+
+	var buf bytes.Buffer
+	if err := (protoCodec{}).Encode(&buf, want); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var got wireRequest
+	if err := (protoCodec{}).Decode(&buf, &got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got != want {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", got, want)
+	}
+}
+
+func TestProtoCodecResponseRoundTrip(t *testing.T) {
+	want := Response{
+		AcceptedTs:   time.Unix(0, 1_000_000_001),
+		RunningTs:    time.Unix(0, 1_000_000_002),
+		FinishedTs:   time.Unix(0, 1_000_000_003),
+		Memory:       123456,
+		Jobs:         3,
+		CPU:          0.5,
+		Alg:          "wfq",
+		QueueDepth:   9,
+		Uptime:       600,
+		Load1:        1.25,
+		Load5:        1.5,
+		Load15:       1.75,
+		CPUPercent:   []float64{0.1, 0.2, 0.3},
+		MemUsed:      2048,
+		MemTotal:     4096,
+		MemPercent:   50.5,
+		NetBytesSent: 111,
+		NetBytesRecv: 222,
+	}
+
+	var buf bytes.Buffer
+	if err := (protoCodec{}).Encode(&buf, want); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var got Response
+	if err := (protoCodec{}).Decode(&buf, &got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if !got.AcceptedTs.Equal(want.AcceptedTs) || !got.RunningTs.Equal(want.RunningTs) || !got.FinishedTs.Equal(want.FinishedTs) {
+		t.Fatalf("timestamp mismatch: got %+v, want %+v", got, want)
+	}
+	got.AcceptedTs, got.RunningTs, got.FinishedTs = time.Time{}, time.Time{}, time.Time{}
+	want.AcceptedTs, want.RunningTs, want.FinishedTs = time.Time{}, time.Time{}, time.Time{}
+	if !bytes.Equal([]byte(got.Alg), []byte(want.Alg)) {
+		t.Fatalf("Alg mismatch: got %q, want %q", got.Alg, want.Alg)
+	}
+	if len(got.CPUPercent) != len(want.CPUPercent) {
+		t.Fatalf("CPUPercent length mismatch: got %v, want %v", got.CPUPercent, want.CPUPercent)
+	}
+	for i := range want.CPUPercent {
+		if got.CPUPercent[i] != want.CPUPercent[i] {
+			t.Fatalf("CPUPercent[%d] mismatch: got %v, want %v", i, got.CPUPercent[i], want.CPUPercent[i])
+		}
+	}
+	got.CPUPercent, want.CPUPercent = nil, nil
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", got, want)
+	}
+}
+
+func TestProtoCodecResponseRoundTripZeroTimestamps(t *testing.T) {
+	want := Response{Error: "overloaded"}
+
+	var buf bytes.Buffer
+	if err := (protoCodec{}).Encode(&buf, want); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var got Response
+	if err := (protoCodec{}).Decode(&buf, &got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !got.AcceptedTs.IsZero() || !got.RunningTs.IsZero() || !got.FinishedTs.IsZero() {
+		t.Fatalf("expected zero timestamps to round-trip as zero, got %+v", got)
+	}
+	if got.Error != want.Error {
+		t.Fatalf("Error mismatch: got %q, want %q", got.Error, want.Error)
+	}
+}
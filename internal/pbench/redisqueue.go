@@ -0,0 +1,149 @@
+package pbench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisDialTimeout bounds how long NewRedisJobQueue waits for the initial
+// Ping before giving up on an unreachable Redis instance.
+const redisDialTimeout = 5 * time.Second
+
+// RedisJobQueue is a JobQueue backed by a Redis list, so the backlog is
+// observable, and recoverable across a restart, from outside this
+// process. Jobs are JSON-encoded (rather than gob, as BoltJobQueue uses)
+// so the list stays inspectable with redis-cli. As with BoltJobQueue,
+// Job.Client only survives in this process's memory; see JobQueue's doc
+// comment.
+type RedisJobQueue struct {
+	client      *redis.Client
+	key         string
+	inflightKey string
+
+	mu    sync.Mutex
+	conns map[string]net.Conn
+}
+
+// NewRedisJobQueue dials addr and prepares the list pair for priority
+// class name.
+func NewRedisJobQueue(addr, name string) (*RedisJobQueue, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr})
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis queue %q: %w", name, err)
+	}
+
+	q := &RedisJobQueue{
+		client:      client,
+		key:         "pbench:queue:" + name,
+		inflightKey: "pbench:inflight:" + name,
+		conns:       make(map[string]net.Conn),
+	}
+
+	if err := q.requeueInflight(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// requeueInflight moves every job left on the inflight list by a previous,
+// crashed process back onto the queue list.
+func (q *RedisJobQueue) requeueInflight(ctx context.Context) error {
+	for {
+		_, err := q.client.RPopLPush(ctx, q.inflightKey, q.key).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (q *RedisJobQueue) Enqueue(j Job) error {
+	data, err := json.Marshal(persistedJob{ID: j.ID, Request: j.Request, Response: j.Response})
+	if err != nil {
+		return err
+	}
+	// Register the connection before the job becomes visible to Dequeue,
+	// so a concurrent Dequeue never hands it out without one.
+	if j.Client != nil {
+		q.mu.Lock()
+		q.conns[j.ID] = j.Client
+		q.mu.Unlock()
+	}
+	if err := q.client.LPush(context.Background(), q.key, data).Err(); err != nil {
+		q.mu.Lock()
+		delete(q.conns, j.ID)
+		q.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Dequeue atomically moves the next job from the queue list to the
+// inflight list and returns it, blocking until one is available or ctx is
+// done.
+func (q *RedisJobQueue) Dequeue(ctx context.Context) (Job, error) {
+	data, err := q.client.BRPopLPush(ctx, q.key, q.inflightKey, 0).Result()
+	if err != nil {
+		return Job{}, err
+	}
+
+	var p persistedJob
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		return Job{}, err
+	}
+
+	job := Job{ID: p.ID, Request: p.Request, Response: p.Response}
+	q.mu.Lock()
+	job.Client = q.conns[job.ID]
+	delete(q.conns, job.ID)
+	q.mu.Unlock()
+
+	return job, nil
+}
+
+// Ack removes the job identified by id from the inflight list, so
+// requeueInflight does not resurrect it on the next restart. Redis has no
+// remove-by-value-from-list primitive keyed by our own ID, so this takes
+// the same linear-scan approach cmd/analyze already uses for samples
+// files, bounded by however many jobs are currently in flight.
+func (q *RedisJobQueue) Ack(id string) error {
+	ctx := context.Background()
+	vals, err := q.client.LRange(ctx, q.inflightKey, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	for _, v := range vals {
+		var p persistedJob
+		if err := json.Unmarshal([]byte(v), &p); err == nil && p.ID == id {
+			return q.client.LRem(ctx, q.inflightKey, 1, v).Err()
+		}
+	}
+	return nil
+}
+
+func (q *RedisJobQueue) Len() int {
+	n, err := q.client.LLen(context.Background(), q.key).Result()
+	if err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// Close releases the underlying Redis client connection pool.
+func (q *RedisJobQueue) Close() error {
+	return q.client.Close()
+}
@@ -1,128 +1,259 @@
-package pbench
-
-import (
-	"context"
-	"encoding/binary"
-	"errors"
-	"fmt"
-	"io"
-	"log"
-	"net"
-	"runtime"
-	"sync"
-	"syscall"
-	"time"
-
-	"golang.org/x/sync/errgroup"
-)
-
-type Server struct {
-	highPrio chan<- Job
-	lowPrio  chan<- Job
-	isDRR    bool
-	buffers  *Pool[[]byte]
-	sync.Mutex
-}
-
-func NewServer(highPrio, lowPrio chan<- Job, isDRR bool) *Server {
-	return &Server{
-		highPrio: highPrio,
-		lowPrio:  lowPrio,
-		isDRR:    isDRR,
-		buffers:  NewPool(func() []byte { b := make([]byte, 4); return b }),
-	}
-}
-
-func (s *Server) Start(ctx context.Context, addr string) error {
-
-	conn, err := net.Listen("tcp4", addr)
-
-	if err != nil {
-		return err
-	}
-
-	g, ctx := errgroup.WithContext(ctx)
-
-	g.Go(func() error {
-		<-ctx.Done()
-		conn.Close()
-		return nil
-	})
-
-	for {
-		client, err := conn.Accept()
-
-		if errors.Is(err, net.ErrClosed) {
-			break
-		} else if err != nil {
-			log.Print(err)
-			continue
-		}
-
-		g.Go(func() error {
-			if err := s.handleConnection(client); parseErr(err) != nil {
-				log.Print(err)
-			}
-			return nil
-		})
-
-	}
-	return g.Wait()
-}
-
-func (s *Server) handleConnection(conn net.Conn) error {
-
-	defer conn.Close()
-
-	for {
-
-		buffer := s.buffers.Get()
-		defer s.buffers.Put(buffer)
-
-		n, err := conn.Read(buffer)
-
-		if err != nil {
-			return err
-		} else if n != 4 {
-			return fmt.Errorf("cannot read request type")
-		}
-
-		r := binary.BigEndian.Uint32(buffer)
-
-		err = s.schedule(Job{
-			Request: Request(r),
-			Response: Response{
-				AcceptedTs: time.Now(),
-				Jobs:       runtime.NumGoroutine() - 4,
-			},
-			Client: conn,
-		})
-
-		if err != nil {
-			log.Printf("cannot schedule: %v", err)
-			continue
-		}
-	}
-}
-
-func (s *Server) schedule(j Job) error {
-	if s.isDRR {
-		if j.Request == SlowRequest {
-			s.lowPrio <- j
-		} else if j.Request == FastRequest {
-			s.highPrio <- j
-		} else {
-			return fmt.Errorf("unknown request type")
-		}
-	} else {
-		s.highPrio <- j
-	}
-	return nil
-}
-
-func parseErr(err error) error {
-	if !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) && errors.Is(err, syscall.ECONNRESET) {
-		return err
-	}
-	return nil
-}
+package pbench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/alarmfox/tesi/internal/pbench/sysstats"
+)
+
+// wireRequest is the payload carried by a single framed request. Payload
+// and Offset address the server's Buffer; Fast requests only use Offset,
+// and the server ignores their Payload.
+type wireRequest struct {
+	Type    Request `json:"type"`
+	Payload int     `json:"payload,omitempty"`
+	Offset  int     `json:"offset,omitempty"`
+}
+
+type Server struct {
+	addr         string
+	highPrio     JobQueue
+	lowPrio      JobQueue
+	splitByClass bool
+	codec        Codec
+	sampler      *sysstats.Sampler
+	startedAt    time.Time
+	jobSeq       uint64
+	sync.Mutex
+	*runner
+}
+
+// NewServer wires sampler, if non-nil, into every accepted Job's Response;
+// pass nil to omit system telemetry entirely. highPrio and lowPrio can be
+// any JobQueue, e.g. the in-memory PriorityQueue or one of the durable
+// backends in boltqueue.go/redisqueue.go. splitByClass tells queueFor
+// whether the scheduler distinguishes SlowRequest/FastRequest into
+// separate queues (drr, wfq, pq, sjf) or treats every request the same
+// (fcfs).
+func NewServer(addr string, highPrio, lowPrio JobQueue, splitByClass bool, codec Codec, sampler *sysstats.Sampler) *Server {
+	return &Server{
+		addr:         addr,
+		highPrio:     highPrio,
+		lowPrio:      lowPrio,
+		splitByClass: splitByClass,
+		codec:        codec,
+		sampler:      sampler,
+		startedAt:    time.Now(),
+		runner:       newRunner(),
+	}
+}
+
+// Stats returns a snapshot of the admission state of both priority queues.
+func (s *Server) Stats() (high, low QueueStats) {
+	return queueStats(s.highPrio), queueStats(s.lowPrio)
+}
+
+// queueStats reports q's depth, plus its admission/drop counters when q is
+// a PriorityQueue; durable backends apply no overflow policy of their own,
+// so they only ever report Depth.
+func queueStats(q JobQueue) QueueStats {
+	if sq, ok := q.(interface{ Stats() QueueStats }); ok {
+		return sq.Stats()
+	}
+	return QueueStats{Depth: q.Len()}
+}
+
+// nextJobID returns a new identifier for an accepted Job, unique for the
+// lifetime of this Server instance; durable JobQueue backends use it to
+// tell jobs apart across Enqueue/Dequeue/Ack.
+func (s *Server) nextJobID() string {
+	n := atomic.AddUint64(&s.jobSeq, 1)
+	return strconv.FormatInt(s.startedAt.UnixNano(), 10) + "-" + strconv.FormatUint(n, 10)
+}
+
+// Start launches the accept loop in the background and returns
+// immediately; Ready closes only once the listener is actually bound, so
+// callers no longer need to guess when it is safe to dial s.addr. Call
+// Wait to block for the server's terminal error.
+func (s *Server) Start(ctx context.Context) error {
+	s.runner.start(ctx, func(ctx context.Context, markReady func(), draining <-chan struct{}) error {
+		conn, err := net.Listen("tcp4", s.addr)
+		if err != nil {
+			return err
+		}
+		markReady()
+
+		g, ctx := errgroup.WithContext(ctx)
+
+		g.Go(func() error {
+			// Stop accepting new connections as soon as Stop is called,
+			// not only once its deadline forces a hard cancel: in-flight
+			// handlers, tracked below in the errgroup, are still given
+			// until that deadline to finish on their own.
+			select {
+			case <-draining:
+			case <-ctx.Done():
+			}
+			conn.Close()
+			return nil
+		})
+
+		for {
+			client, err := conn.Accept()
+
+			if errors.Is(err, net.ErrClosed) {
+				break
+			} else if err != nil {
+				log.Print(err)
+				continue
+			}
+
+			g.Go(func() error {
+				// handleConnection blocks in Decode for as long as the
+				// client keeps the socket open, so close it once ctx is done.
+				handled := make(chan struct{})
+				defer close(handled)
+				go func() {
+					select {
+					case <-ctx.Done():
+						client.Close()
+					case <-handled:
+					}
+				}()
+				if err := s.handleConnection(client); parseErr(err) != nil {
+					log.Print(err)
+				}
+				return nil
+			})
+
+		}
+		return g.Wait()
+	})
+	return nil
+}
+
+// Stop cancels the accept loop and waits for in-flight connection
+// handlers to return, up to ctx's deadline.
+func (s *Server) Stop(ctx context.Context) error {
+	return s.runner.stop(ctx)
+}
+
+// handleConnection is the reader goroutine for a single accepted
+// connection: it decodes framed requests in a loop, for as long as the
+// client keeps the connection open, and pushes one Job per frame into the
+// scheduler. Responses are written back on the same socket by the job
+// consumer once the scheduler has run the job.
+func (s *Server) handleConnection(conn net.Conn) error {
+
+	defer conn.Close()
+
+	for {
+		var req wireRequest
+		if err := s.codec.Decode(conn, &req); err != nil {
+			return err
+		}
+
+		q, err := s.queueFor(req.Type)
+		if err != nil {
+			log.Printf("cannot schedule: %v", err)
+			continue
+		}
+
+		resp := Response{
+			AcceptedTs: time.Now(),
+			Jobs:       runtime.NumGoroutine() - 4,
+			QueueDepth: q.Len(),
+		}
+		if s.sampler != nil {
+			snap := s.sampler.Latest()
+			resp.Uptime = snap.Uptime
+			resp.Load1 = snap.Load1
+			resp.Load5 = snap.Load5
+			resp.Load15 = snap.Load15
+			resp.CPUPercent = snap.CPUPercent
+			resp.MemUsed = snap.MemUsed
+			resp.MemTotal = snap.MemTotal
+			resp.MemPercent = snap.MemPercent
+			resp.NetBytesSent = snap.NetBytesSent
+			resp.NetBytesRecv = snap.NetBytesRecv
+		}
+
+		job := Job{
+			ID:       s.nextJobID(),
+			Payload:  req.Payload,
+			Offset:   req.Offset,
+			Request:  req.Type,
+			Response: resp,
+			Client:   conn,
+		}
+
+		if err := q.Enqueue(job); err != nil {
+			if !errors.Is(err, ErrOverloaded) {
+				log.Printf("cannot schedule: %v", err)
+				continue
+			}
+			resp := job.Response
+			resp.Error = err.Error()
+			if werr := WriteResponse(conn, s.codec, resp); werr != nil {
+				return werr
+			}
+		}
+	}
+}
+
+// WriteResponse encodes and writes r to conn using codec, matching how
+// handleConnection decodes requests on the other side.
+func WriteResponse(conn net.Conn, codec Codec, r Response) error {
+	return codec.Encode(conn, r)
+}
+
+// writeRequest encodes and writes req to conn using codec, the client-side
+// counterpart of handleConnection's request decoding.
+func writeRequest(conn net.Conn, codec Codec, req wireRequest) error {
+	return codec.Encode(conn, req)
+}
+
+// readResponse reads and decodes a single framed Response using codec, the
+// client-side counterpart of WriteResponse.
+func readResponse(conn net.Conn, codec Codec) (Response, error) {
+	var resp Response
+	err := codec.Decode(conn, &resp)
+	return resp, err
+}
+
+// queueFor returns the priority queue r should be admitted to. With a
+// scheduler that doesn't split by class, every request shares the
+// high-priority queue.
+func (s *Server) queueFor(r Request) (JobQueue, error) {
+	if !s.splitByClass {
+		return s.highPrio, nil
+	}
+	switch r {
+	case SlowRequest:
+		return s.lowPrio, nil
+	case FastRequest:
+		return s.highPrio, nil
+	default:
+		return nil, fmt.Errorf("unknown request type")
+	}
+}
+
+func parseErr(err error) error {
+	if !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) && errors.Is(err, syscall.ECONNRESET) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package pbench
+
+import (
+	"context"
+	"sync"
+)
+
+// Service is a component with a managed lifecycle. Start launches the
+// service in the background and returns immediately; Ready closes once the
+// service is actually able to do work (e.g. its listener is bound); Wait
+// blocks until the service's background goroutine has returned and reports
+// its terminal error; Stop asks the service to wind down, waiting up to
+// ctx's deadline before giving up.
+type Service interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+	Ready() <-chan struct{}
+	Wait() error
+}
+
+// runner gives a Service idempotent start/stop bookkeeping so concrete
+// services don't each reimplement it: Start is a sync.Once around the
+// actual goroutine, Stop first asks work to drain whatever it already has
+// queued and only cancels the underlying context -- aborting that drain --
+// if ctx's own deadline arrives first.
+type runner struct {
+	startOnce sync.Once
+	drainOnce sync.Once
+	ready     chan struct{}
+	draining  chan struct{}
+	done      chan struct{}
+	cancel    context.CancelFunc
+	err       error
+}
+
+func newRunner() *runner {
+	return &runner{
+		ready:    make(chan struct{}),
+		draining: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// start launches work in a goroutine exactly once; later calls are no-ops.
+// work is handed a context derived from the one passed to start, a
+// markReady func it should call once it is actually serving requests, and
+// a draining channel that closes once stop is called: work should react to
+// it by finishing whatever is already in flight or queued and returning,
+// rather than waiting on brand-new arrivals, so stop can observe a clean
+// exit before ctx's hard deadline forces one.
+func (r *runner) start(ctx context.Context, work func(ctx context.Context, markReady func(), draining <-chan struct{}) error) {
+	r.startOnce.Do(func() {
+		ctx, cancel := context.WithCancel(ctx)
+		r.cancel = cancel
+		go func() {
+			defer close(r.done)
+			r.err = work(ctx, r.markReady, r.draining)
+		}()
+	})
+}
+
+func (r *runner) markReady() {
+	select {
+	case <-r.ready:
+	default:
+		close(r.ready)
+	}
+}
+
+func (r *runner) Ready() <-chan struct{} { return r.ready }
+
+func (r *runner) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// stop closes draining so work can wind down on its own, then waits for it
+// to exit, up to ctx's deadline; only once that deadline passes does it
+// cancel the context started with, aborting whatever drain is still in
+// progress.
+func (r *runner) stop(ctx context.Context) error {
+	r.drainOnce.Do(func() { close(r.draining) })
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		if r.cancel != nil {
+			r.cancel()
+		}
+		<-r.done
+		return ctx.Err()
+	}
+}
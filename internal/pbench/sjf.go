@@ -0,0 +1,161 @@
+package pbench
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// sjfEWMAAlpha weights a newly observed residence time against the
+// running estimate: higher reacts faster to recent load, lower smooths
+// out noise between individual jobs.
+const sjfEWMAAlpha = 0.2
+
+// ResidenceObserver receives a completed job's actual residence time.
+// Worker feeds every finished job to one, if the active scheduler
+// implements it, so a scheduler like SJF can adapt its size estimates to
+// recently observed load instead of only ever using its seed value.
+type ResidenceObserver interface {
+	Observe(r Request, d time.Duration)
+}
+
+type sjfClass struct {
+	request  Request
+	queue    JobQueue
+	estimate uint64 // nanoseconds, accessed atomically
+}
+
+// SJF is a Shortest-Job-First scheduler that approximates "job size" with
+// an exponentially-weighted moving average of residence times actually
+// observed for each Request type (see Observe), since the benchmark only
+// ever distinguishes job cost by request type, not per-job. Every round it
+// tries the registered class with the smallest current estimate first.
+type SJF struct {
+	classes []*sjfClass
+	outChan chan Job
+	*runner
+}
+
+func NewSJF(outChan chan Job) *SJF {
+	return &SJF{
+		outChan: outChan,
+		runner:  newRunner(),
+	}
+}
+
+// Input registers in as the queue jobs of type r are admitted to.
+// initialEstimate seeds the EWMA before any completion has been observed
+// for r.
+func (s *SJF) Input(r Request, in JobQueue, initialEstimate time.Duration) {
+	s.classes = append(s.classes, &sjfClass{
+		request:  r,
+		queue:    in,
+		estimate: uint64(initialEstimate),
+	})
+}
+
+// Observe folds a completed job's actual residence time d into the EWMA
+// estimate for its Request type r, so future rounds prefer whichever
+// class now looks cheapest.
+func (s *SJF) Observe(r Request, d time.Duration) {
+	for _, c := range s.classes {
+		if c.request != r {
+			continue
+		}
+		for {
+			old := atomic.LoadUint64(&c.estimate)
+			next := uint64(sjfEWMAAlpha*float64(d) + (1-sjfEWMAAlpha)*float64(old))
+			if atomic.CompareAndSwapUint64(&c.estimate, old, next) {
+				return
+			}
+		}
+	}
+}
+
+// Start launches the SJF goroutine, which every round orders the
+// registered classes by ascending current estimate and dispatches the
+// first job it can dequeue, and returns immediately; call Wait to block
+// for its terminal error.
+//
+// The SJF goroutine exits when ctx is cancelled, closing the output
+// channel upon termination. Once Stop is called, it keeps draining classes
+// that still have a backlog but stops waiting on brand-new arrivals.
+func (s *SJF) Start(ctx context.Context) error {
+	s.runner.start(ctx, func(ctx context.Context, markReady func(), draining <-chan struct{}) error {
+		markReady()
+		defer close(s.outChan)
+
+		if len(s.classes) == 0 {
+			<-ctx.Done()
+			return nil
+		}
+
+		sources := make([]*flowSource, len(s.classes))
+		for i, c := range s.classes {
+			sources[i] = newFlowSource(ctx, c.queue)
+		}
+
+		// waitCtx also ends once Stop is called, so a wait for new
+		// arrivals that is already in progress stops then.
+		waitCtx, cancelWait := context.WithCancel(ctx)
+		defer cancelWait()
+		go func() {
+			select {
+			case <-draining:
+			case <-waitCtx.Done():
+			}
+			cancelWait()
+		}()
+
+		order := make([]int, len(s.classes))
+		for i := range order {
+			order[i] = i
+		}
+
+		for {
+			sort.SliceStable(order, func(i, j int) bool {
+				return atomic.LoadUint64(&s.classes[order[i]].estimate) < atomic.LoadUint64(&s.classes[order[j]].estimate)
+			})
+
+			dispatched := false
+			for _, idx := range order {
+				job, ok := sources[idx].tryRecv()
+				if !ok {
+					continue
+				}
+				s.outChan <- job
+				s.classes[idx].queue.Ack(job.ID)
+				dispatched = true
+				break
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			if !dispatched {
+				select {
+				case <-draining:
+					// Every class drained and nothing new is coming; stop
+					// instead of waiting on arrivals that will never come.
+					return nil
+				default:
+				}
+				// Every class was empty this round; block until one of
+				// them actually has something instead of spinning.
+				idx, job, ok := waitAny(waitCtx, sources)
+				if !ok {
+					return nil
+				}
+				s.outChan <- job
+				s.classes[idx].queue.Ack(job.ID)
+			}
+		}
+	})
+	return nil
+}
+
+// Stop cancels the SJF goroutine and waits for it to exit, up to ctx's
+// deadline.
+func (s *SJF) Stop(ctx context.Context) error {
+	return s.runner.stop(ctx)
+}
@@ -0,0 +1,83 @@
+package pbench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// statsSnapshot is the JSON body StatsServer serves at /stats.
+type statsSnapshot struct {
+	High QueueStats `json:"high"`
+	Low  QueueStats `json:"low"`
+}
+
+// StatsServer exposes a Server's priority queue backlog depth as JSON over
+// HTTP, so an external monitor can poll it without speaking pbench's own
+// wire protocol or sharing a process with the scheduler.
+type StatsServer struct {
+	addr  string
+	stats func() (high, low QueueStats)
+	*runner
+}
+
+// NewStatsServer serves stats, called fresh on every request, at
+// GET /stats on addr.
+func NewStatsServer(addr string, stats func() (high, low QueueStats)) *StatsServer {
+	return &StatsServer{
+		addr:   addr,
+		stats:  stats,
+		runner: newRunner(),
+	}
+}
+
+// Start launches the HTTP server in the background and returns
+// immediately; Ready closes only once the listener is actually bound.
+// Call Wait to block for its terminal error.
+func (s *StatsServer) Start(ctx context.Context) error {
+	s.runner.start(ctx, func(ctx context.Context, markReady func(), draining <-chan struct{}) error {
+		ln, err := net.Listen("tcp4", s.addr)
+		if err != nil {
+			return err
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/stats", s.handleStats)
+		srv := &http.Server{Handler: mux}
+		markReady()
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- srv.Serve(ln) }()
+
+		// React to draining, not ctx: Stop only cancels ctx once its own
+		// deadline elapses, which would leave no time for Shutdown's own
+		// graceful drain below.
+		select {
+		case <-draining:
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+			defer cancel()
+			srv.Shutdown(shutdownCtx)
+			return nil
+		case err := <-errCh:
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		}
+	})
+	return nil
+}
+
+// Stop cancels the HTTP server and waits for it to exit, up to ctx's
+// deadline.
+func (s *StatsServer) Stop(ctx context.Context) error {
+	return s.runner.stop(ctx)
+}
+
+func (s *StatsServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	high, low := s.stats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statsSnapshot{High: high, Low: low})
+}
@@ -0,0 +1,70 @@
+package pbench
+
+import (
+	"context"
+	"time"
+)
+
+// stopTimeout bounds how long Supervisor.Run waits for a single service to
+// drain in-flight work during shutdown before moving on to the next one.
+const stopTimeout = 5 * time.Second
+
+// Supervisor owns an ordered list of Services, starting them in
+// registration order -- waiting for each one's Ready before starting the
+// next -- and stopping them in reverse order, so that, e.g., a pipeline
+// registered consumer-before-producer (as cmd/server registers worker,
+// scheduler, server) is shut down producer-first: the
+// server stops accepting new connections, the scheduler drains its queues
+// and closes its output, and only then does the worker stop, having drained
+// whatever the scheduler handed it in the meantime.
+type Supervisor struct {
+	services []Service
+}
+
+func NewSupervisor(services ...Service) *Supervisor {
+	return &Supervisor{services: services}
+}
+
+// Run starts every service in order, then blocks until ctx is cancelled or
+// one of the services exits on its own, then stops every service in
+// reverse registration order. It returns the first non-nil error seen,
+// whether that came from starting, running, or stopping a service.
+func (sv *Supervisor) Run(ctx context.Context) error {
+	for _, s := range sv.services {
+		if err := s.Start(ctx); err != nil {
+			return err
+		}
+		// Don't start the next service until this one is actually ready:
+		// e.g. a scheduler's output channel should only gain a reader once
+		// its Worker is really consuming from it, and a client should only
+		// be able to dial a Server once its listener is really bound.
+		select {
+		case <-s.Ready():
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	failed := make(chan error, len(sv.services))
+	for _, s := range sv.services {
+		s := s
+		go func() { failed <- s.Wait() }()
+	}
+
+	var firstErr error
+	select {
+	case <-ctx.Done():
+	case err := <-failed:
+		firstErr = err
+	}
+
+	for i := len(sv.services) - 1; i >= 0; i-- {
+		stopCtx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+		if err := sv.services[i].Stop(stopCtx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		cancel()
+	}
+
+	return firstErr
+}
@@ -0,0 +1,121 @@
+// Package sysstats samples host-wide load, memory, per-core CPU and
+// network counters on a timer, so callers on a request's hot path can
+// attach the latest reading to a response instead of querying the kernel
+// themselves for every request.
+package sysstats
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/host"
+	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/mem"
+	gonet "github.com/shirou/gopsutil/net"
+)
+
+// Snapshot is one point-in-time reading of host telemetry.
+type Snapshot struct {
+	Uptime       uint64    `json:"uptime"`
+	Load1        float64   `json:"load1"`
+	Load5        float64   `json:"load5"`
+	Load15       float64   `json:"load15"`
+	CPUPercent   []float64 `json:"cpu_percent"`
+	MemUsed      uint64    `json:"mem_used"`
+	MemTotal     uint64    `json:"mem_total"`
+	MemPercent   float64   `json:"mem_percent"`
+	NetBytesSent uint64    `json:"net_bytes_sent"`
+	NetBytesRecv uint64    `json:"net_bytes_recv"`
+}
+
+// Sampler refreshes a Snapshot once per Interval on its own goroutine and
+// publishes it for Latest to read, so sampling never happens on a
+// request-handling goroutine and therefore never perturbs the
+// measurements it is meant to explain.
+type Sampler struct {
+	interval  time.Duration
+	mu        sync.RWMutex
+	latest    Snapshot
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+func NewSampler(interval time.Duration) *Sampler {
+	return &Sampler{
+		interval: interval,
+		ready:    make(chan struct{}),
+	}
+}
+
+// Ready closes once the first sample has been taken, so callers can wait
+// for a non-zero Snapshot before serving requests.
+func (s *Sampler) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Latest returns the most recently published Snapshot.
+func (s *Sampler) Latest() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latest
+}
+
+// Run samples the host once per Interval until ctx is cancelled. Call it
+// in its own goroutine; it blocks until ctx is done.
+func (s *Sampler) Run(ctx context.Context) error {
+	s.sample()
+	s.readyOnce.Do(func() { close(s.ready) })
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.sample()
+		}
+	}
+}
+
+func (s *Sampler) sample() {
+	var snap Snapshot
+
+	if avg, err := load.Avg(); err != nil {
+		log.Print(err)
+	} else {
+		snap.Load1, snap.Load5, snap.Load15 = avg.Load1, avg.Load5, avg.Load15
+	}
+
+	if info, err := host.Info(); err != nil {
+		log.Print(err)
+	} else {
+		snap.Uptime = info.Uptime
+	}
+
+	if percents, err := cpu.Percent(0, true); err != nil {
+		log.Print(err)
+	} else {
+		snap.CPUPercent = percents
+	}
+
+	if vm, err := mem.VirtualMemory(); err != nil {
+		log.Print(err)
+	} else {
+		snap.MemUsed, snap.MemTotal, snap.MemPercent = vm.Used, vm.Total, vm.UsedPercent
+	}
+
+	if counters, err := gonet.IOCounters(false); err != nil {
+		log.Print(err)
+	} else if len(counters) > 0 {
+		snap.NetBytesSent, snap.NetBytesRecv = counters[0].BytesSent, counters[0].BytesRecv
+	}
+
+	s.mu.Lock()
+	s.latest = snap
+	s.mu.Unlock()
+}
@@ -0,0 +1,98 @@
+package pbench
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"time"
+)
+
+// Worker consumes jobs from a scheduler's output channel, stamps each
+// Response with its running/finished timestamps and the current resource
+// usage, runs it through buffer to simulate the request's cost, and writes
+// the response back to the client that issued it.
+type Worker struct {
+	jobs     <-chan Job
+	buffer   *Buffer
+	codec    Codec
+	observer ResidenceObserver
+	*runner
+}
+
+// NewWorker reports each job's actual residence time to observer, if
+// non-nil, after it finishes, so a scheduler such as SJF can adapt its
+// size estimates to observed load. Pass nil for schedulers that don't
+// implement ResidenceObserver.
+func NewWorker(jobs <-chan Job, buffer *Buffer, codec Codec, observer ResidenceObserver) *Worker {
+	return &Worker{
+		jobs:     jobs,
+		buffer:   buffer,
+		codec:    codec,
+		observer: observer,
+		runner:   newRunner(),
+	}
+}
+
+// Start launches the worker goroutine and returns immediately; call Wait
+// to block for its terminal error. The worker exits once jobs is closed or
+// ctx is cancelled; since Stop only cancels ctx once its own deadline
+// elapses, the worker naturally keeps draining jobs -- fed by whatever
+// scheduler is upstream -- until that scheduler finishes its own drain and
+// closes jobs, or the deadline forces an early exit.
+func (w *Worker) Start(ctx context.Context) error {
+	w.runner.start(ctx, func(ctx context.Context, markReady func(), draining <-chan struct{}) error {
+		markReady()
+		var memory runtime.MemStats
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case job, ok := <-w.jobs:
+				if !ok {
+					return nil
+				}
+				if job.Client == nil {
+					// A durable JobQueue handed back a job whose original
+					// connection died with a previous process instance;
+					// there is nowhere to send a response.
+					continue
+				}
+				job.Response.RunningTs = time.Now()
+				switch job.Request {
+				case SlowRequest:
+					if err := w.buffer.Slow(job.Payload, job.Offset); err != nil {
+						log.Printf("buffer: %v", err)
+					}
+				case FastRequest:
+					if _, err := w.buffer.Fast(job.Offset); err != nil {
+						log.Printf("buffer: %v", err)
+					}
+				}
+				runtime.ReadMemStats(&memory)
+				job.Response.Memory = memory.Sys
+				job.Response.FinishedTs = time.Now()
+
+				// Load1 was already sampled once per sysstats.Sampler
+				// interval by its own goroutine and attached to the
+				// response at admission time; reuse it here instead of
+				// taking another load.Avg() syscall on this hot path.
+				job.Response.CPU = job.Response.Load1
+
+				if w.observer != nil {
+					w.observer.Observe(job.Request, job.Response.FinishedTs.Sub(job.Response.AcceptedTs))
+				}
+
+				if err := WriteResponse(job.Client, w.codec, job.Response); err != nil {
+					log.Printf("response: %v", err)
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// Stop cancels the worker goroutine and waits for it to exit, up to ctx's
+// deadline.
+func (w *Worker) Stop(ctx context.Context) error {
+	return w.runner.stop(ctx)
+}
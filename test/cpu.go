@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
 
-	"github.com/shirou/gopsutil/load"
+	"github.com/alarmfox/tesi/internal/pbench/sysstats"
 )
 
 func main() {
-	avg, err := load.Avg()
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.Printf("%+v", avg.String())
+	sampler := sysstats.NewSampler(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go sampler.Run(ctx)
+
+	<-sampler.Ready()
+	log.Printf("%+v", sampler.Latest())
 }